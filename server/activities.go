@@ -0,0 +1,476 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Activity is a bookable visit experience (safari, tasting, picnic) with its
+// catalog data and ticket pricing
+type Activity struct {
+	ID                     int64             `json:"id"`
+	Slug                   string            `json:"slug"` // safari, tasting, picnic
+	Name                   string            `json:"name"` // Swedish default name
+	Names                  map[string]string `json:"names"`
+	Descriptions           map[string]string `json:"descriptions"`
+	DefaultDurationMinutes int               `json:"defaultDurationMinutes" validate:"min=1"`
+	DefaultCapacity        int               `json:"defaultCapacity" validate:"min=1"`
+	SeasonStart            string            `json:"seasonStart"` // MM-DD, empty = all year
+	SeasonEnd              string            `json:"seasonEnd"`   // MM-DD, may wrap over new year
+	Images                 []string          `json:"images"`
+	Active                 bool              `json:"active"`
+	PriceAdult             float64           `json:"priceAdult" validate:"min=0"`
+	PriceChild             float64           `json:"priceChild" validate:"min=0"`
+	PriceSenior            float64           `json:"priceSenior" validate:"min=0"`
+	MinGroupSize           int               `json:"minGroupSize" validate:"min=0"`
+	MaxGroupSize           int               `json:"maxGroupSize" validate:"min=0"`
+	GroupDiscounts         []GroupDiscount   `json:"groupDiscounts"`
+	ResourceIDs            []int64           `json:"resourceIds"` // guides, vehicles, areas each slot needs
+	Outdoor                bool              `json:"outdoor"`     // checked against the weather forecast
+}
+
+// GroupDiscount applies a percentage off when a party reaches MinSize
+type GroupDiscount struct {
+	MinSize         int `json:"minSize" validate:"min=1"`
+	DiscountPercent int `json:"discountPercent" validate:"min=1,max=100"`
+}
+
+// Tickets holds the quantity per ticket type in a booking
+type Tickets struct {
+	Adult  int `json:"adult" validate:"min=0,max=500"`
+	Child  int `json:"child" validate:"min=0,max=500"`
+	Senior int `json:"senior" validate:"min=0,max=500"`
+}
+
+// Total returns the party size
+func (t Tickets) Total() int {
+	return t.Adult + t.Child + t.Senior
+}
+
+var (
+	slugPattern       = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	monthDayPattern   = regexp.MustCompile(`^(0[1-9]|1[0-2])-(0[1-9]|[12][0-9]|3[01])$`)
+	activityLocales   = []string{"sv", "fi", "en"}
+	activitySelectSQL = `SELECT id, slug, name, default_duration_minutes, default_capacity, season_start, season_end, images, active,
+		price_adult, price_child, price_senior, min_group_size, max_group_size, outdoor FROM activities`
+)
+
+func initActivityTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS activities (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		slug TEXT UNIQUE,
+		name TEXT,
+		price_adult REAL DEFAULT 0,
+		price_child REAL DEFAULT 0,
+		price_senior REAL DEFAULT 0,
+		min_group_size INTEGER DEFAULT 1,
+		max_group_size INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS activity_group_discounts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		activity_id INTEGER,
+		min_size INTEGER,
+		discount_percent INTEGER,
+		FOREIGN KEY(activity_id) REFERENCES activities(id)
+	);
+	CREATE TABLE IF NOT EXISTS activity_translations (
+		activity_id INTEGER,
+		locale TEXT,
+		name TEXT,
+		description TEXT,
+		PRIMARY KEY (activity_id, locale),
+		FOREIGN KEY(activity_id) REFERENCES activities(id)
+	);
+	`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating activity tables: %v", err)
+	}
+
+	// Migrations for existing activities table
+	db.Exec("ALTER TABLE activities ADD COLUMN default_duration_minutes INTEGER DEFAULT 90")
+	db.Exec("ALTER TABLE activities ADD COLUMN default_capacity INTEGER DEFAULT 12")
+	db.Exec("ALTER TABLE activities ADD COLUMN season_start TEXT DEFAULT ''")
+	db.Exec("ALTER TABLE activities ADD COLUMN season_end TEXT DEFAULT ''")
+	db.Exec("ALTER TABLE activities ADD COLUMN images TEXT DEFAULT '[]'")
+	db.Exec("ALTER TABLE activities ADD COLUMN active BOOLEAN DEFAULT 1")
+	if _, err := db.Exec("ALTER TABLE activities ADD COLUMN outdoor BOOLEAN DEFAULT 0"); err == nil {
+		db.Exec("UPDATE activities SET outdoor = 1 WHERE slug IN ('safari', 'picnic')")
+	}
+
+	// Migrations: ticket quantities and server-computed total on bookings
+	db.Exec("ALTER TABLE bookings ADD COLUMN qty_adult INTEGER DEFAULT 0")
+	db.Exec("ALTER TABLE bookings ADD COLUMN qty_child INTEGER DEFAULT 0")
+	db.Exec("ALTER TABLE bookings ADD COLUMN qty_senior INTEGER DEFAULT 0")
+	db.Exec("ALTER TABLE bookings ADD COLUMN total_amount REAL DEFAULT 0")
+
+	// Seed the activities that already exist as slot.activity strings
+	seed := []Activity{
+		{
+			Slug: "safari", Name: "Äppelsafari",
+			Names:                  map[string]string{"sv": "Äppelsafari", "fi": "Omenasafari", "en": "Apple Safari"},
+			Descriptions:           map[string]string{"sv": "Guidad tur genom äppelodlingen.", "en": "Guided tour through the orchard."},
+			DefaultDurationMinutes: 90, DefaultCapacity: 12, SeasonStart: "05-01", SeasonEnd: "10-31",
+			PriceAdult: 25, PriceChild: 12, PriceSenior: 20, MinGroupSize: 1, MaxGroupSize: 12,
+			GroupDiscounts: []GroupDiscount{{MinSize: 8, DiscountPercent: 10}},
+			Outdoor:        true,
+		},
+		{
+			Slug: "tasting", Name: "Mustprovning",
+			Names:                  map[string]string{"sv": "Mustprovning", "fi": "Mehumaistelu", "en": "Juice Tasting"},
+			Descriptions:           map[string]string{"sv": "Provning av gårdens muster och cider.", "en": "Tasting of the farm's juices and cider."},
+			DefaultDurationMinutes: 60, DefaultCapacity: 16,
+			PriceAdult: 20, PriceChild: 8, PriceSenior: 16, MinGroupSize: 2, MaxGroupSize: 16,
+			GroupDiscounts: []GroupDiscount{{MinSize: 10, DiscountPercent: 15}},
+		},
+		{
+			Slug: "picnic", Name: "Picknick",
+			Names:                  map[string]string{"sv": "Picknick", "fi": "Piknik", "en": "Picnic"},
+			Descriptions:           map[string]string{"sv": "Picknick bland äppelträden.", "en": "Picnic among the apple trees."},
+			DefaultDurationMinutes: 120, DefaultCapacity: 10, SeasonStart: "06-01", SeasonEnd: "09-15",
+			PriceAdult: 35, PriceChild: 15, PriceSenior: 30, MinGroupSize: 1, MaxGroupSize: 10,
+			Outdoor: true,
+		},
+	}
+	for _, a := range seed {
+		var exists int
+		db.QueryRow("SELECT COUNT(*) FROM activities WHERE slug = ?", a.Slug).Scan(&exists)
+		if exists > 0 {
+			continue
+		}
+		a.Active = true
+		if err := saveActivity(&a); err != nil {
+			log.Printf("Error seeding activity %s: %v", a.Slug, err)
+		}
+	}
+}
+
+// activityName returns the display name for an activity slug, or the slug
+func activityName(slug string) string {
+	if a, err := getActivityBySlug(db, slug); err == nil {
+		return a.Name
+	}
+	return slug
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// scanActivity reads one row selected with activitySelectSQL
+func scanActivity(row interface{ Scan(...interface{}) error }) (*Activity, error) {
+	var a Activity
+	var images string
+	err := row.Scan(&a.ID, &a.Slug, &a.Name, &a.DefaultDurationMinutes, &a.DefaultCapacity, &a.SeasonStart, &a.SeasonEnd, &images, &a.Active,
+		&a.PriceAdult, &a.PriceChild, &a.PriceSenior, &a.MinGroupSize, &a.MaxGroupSize, &a.Outdoor)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(images), &a.Images)
+	return &a, nil
+}
+
+// loadActivityDetails fills translations and group discounts
+func loadActivityDetails(q queryer, a *Activity) error {
+	a.Names = map[string]string{}
+	a.Descriptions = map[string]string{}
+	rows, err := q.Query("SELECT locale, name, description FROM activity_translations WHERE activity_id = ?", a.ID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var locale, name, desc string
+		if err := rows.Scan(&locale, &name, &desc); err != nil {
+			continue
+		}
+		if name != "" {
+			a.Names[locale] = name
+		}
+		if desc != "" {
+			a.Descriptions[locale] = desc
+		}
+	}
+	rows.Close()
+
+	rows, err = q.Query("SELECT min_size, discount_percent FROM activity_group_discounts WHERE activity_id = ? ORDER BY min_size ASC", a.ID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var d GroupDiscount
+		if err := rows.Scan(&d.MinSize, &d.DiscountPercent); err != nil {
+			continue
+		}
+		a.GroupDiscounts = append(a.GroupDiscounts, d)
+	}
+	rows.Close()
+
+	a.ResourceIDs, err = activityResourceIDs(q, a.ID)
+	return err
+}
+
+// getActivityBySlug loads an activity with its translations and group discounts
+func getActivityBySlug(q queryer, slug string) (*Activity, error) {
+	a, err := scanActivity(q.QueryRow(activitySelectSQL+" WHERE slug = ?", slug))
+	if err != nil {
+		return nil, err
+	}
+	if err := loadActivityDetails(q, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// listActivities returns the catalog, optionally including inactive entries
+func listActivities(includeInactive bool) ([]Activity, error) {
+	query := activitySelectSQL
+	if !includeInactive {
+		query += " WHERE active = 1"
+	}
+	rows, err := db.Query(query + " ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	var activities []Activity
+	for rows.Next() {
+		a, err := scanActivity(rows)
+		if err != nil {
+			continue
+		}
+		activities = append(activities, *a)
+	}
+	rows.Close()
+
+	for i := range activities {
+		loadActivityDetails(db, &activities[i])
+	}
+	return activities, nil
+}
+
+// Validate checks the catalog fields before saving
+func (a *Activity) Validate() error {
+	if !slugPattern.MatchString(a.Slug) {
+		return fmt.Errorf("slug must be lowercase letters, digits and dashes")
+	}
+	if a.Name == "" {
+		a.Name = a.Names["sv"]
+	}
+	if a.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if (a.SeasonStart == "") != (a.SeasonEnd == "") {
+		return fmt.Errorf("seasonStart and seasonEnd must be set together")
+	}
+	for _, md := range []string{a.SeasonStart, a.SeasonEnd} {
+		if md != "" && !monthDayPattern.MatchString(md) {
+			return fmt.Errorf("season dates must be MM-DD, got %q", md)
+		}
+	}
+	if a.MaxGroupSize > 0 && a.MinGroupSize > a.MaxGroupSize {
+		return fmt.Errorf("minGroupSize cannot exceed maxGroupSize")
+	}
+	return nil
+}
+
+// InSeason reports whether t falls within the activity's season window
+func (a *Activity) InSeason(t time.Time) bool {
+	if a.SeasonStart == "" {
+		return true
+	}
+	md := t.In(farmLocation).Format("01-02")
+	if a.SeasonStart <= a.SeasonEnd {
+		return md >= a.SeasonStart && md <= a.SeasonEnd
+	}
+	// Window wraps over new year, e.g. 11-01 to 02-28
+	return md >= a.SeasonStart || md <= a.SeasonEnd
+}
+
+// saveActivity inserts or updates an activity together with its
+// translations and group discounts
+func saveActivity(a *Activity) error {
+	if a.Images == nil {
+		a.Images = []string{}
+	}
+	images, _ := json.Marshal(a.Images)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if a.ID == 0 {
+		res, err := tx.Exec(`INSERT INTO activities (slug, name, default_duration_minutes, default_capacity, season_start, season_end, images, active,
+			price_adult, price_child, price_senior, min_group_size, max_group_size, outdoor) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			a.Slug, a.Name, a.DefaultDurationMinutes, a.DefaultCapacity, a.SeasonStart, a.SeasonEnd, string(images), a.Active,
+			a.PriceAdult, a.PriceChild, a.PriceSenior, a.MinGroupSize, a.MaxGroupSize, a.Outdoor)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		a.ID, _ = res.LastInsertId()
+	} else {
+		_, err := tx.Exec(`UPDATE activities SET name=?, default_duration_minutes=?, default_capacity=?, season_start=?, season_end=?, images=?, active=?,
+			price_adult=?, price_child=?, price_senior=?, min_group_size=?, max_group_size=?, outdoor=? WHERE id=?`,
+			a.Name, a.DefaultDurationMinutes, a.DefaultCapacity, a.SeasonStart, a.SeasonEnd, string(images), a.Active,
+			a.PriceAdult, a.PriceChild, a.PriceSenior, a.MinGroupSize, a.MaxGroupSize, a.Outdoor, a.ID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// Replace translations and discounts wholesale, they are small lists
+	tx.Exec("DELETE FROM activity_translations WHERE activity_id = ?", a.ID)
+	for _, locale := range activityLocales {
+		name, desc := a.Names[locale], a.Descriptions[locale]
+		if name == "" && desc == "" {
+			continue
+		}
+		if _, err := tx.Exec("INSERT INTO activity_translations (activity_id, locale, name, description) VALUES (?, ?, ?, ?)", a.ID, locale, name, desc); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	tx.Exec("DELETE FROM activity_group_discounts WHERE activity_id = ?", a.ID)
+	for _, d := range a.GroupDiscounts {
+		if _, err := tx.Exec("INSERT INTO activity_group_discounts (activity_id, min_size, discount_percent) VALUES (?, ?, ?)", a.ID, d.MinSize, d.DiscountPercent); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	tx.Exec("DELETE FROM activity_resources WHERE activity_id = ?", a.ID)
+	for _, rid := range a.ResourceIDs {
+		if _, err := tx.Exec("INSERT INTO activity_resources (activity_id, resource_id) VALUES (?, ?)", a.ID, rid); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Price computes the total for a party, enforcing group size limits and
+// applying the largest group discount the party qualifies for
+func (a *Activity) Price(t Tickets) (float64, error) {
+	// A negative count would pass the size checks at a lower price
+	if t.Adult < 0 || t.Child < 0 || t.Senior < 0 {
+		return 0, fmt.Errorf("ticket counts cannot be negative")
+	}
+	size := t.Total()
+	if size < 1 {
+		return 0, fmt.Errorf("at least one ticket is required")
+	}
+	if a.MinGroupSize > 0 && size < a.MinGroupSize {
+		return 0, fmt.Errorf("%s requires at least %d people", a.Name, a.MinGroupSize)
+	}
+	if a.MaxGroupSize > 0 && size > a.MaxGroupSize {
+		return 0, fmt.Errorf("%s allows at most %d people per booking", a.Name, a.MaxGroupSize)
+	}
+
+	total := float64(t.Adult)*a.PriceAdult + float64(t.Child)*a.PriceChild + float64(t.Senior)*a.PriceSenior
+
+	discount := 0
+	for _, d := range a.GroupDiscounts {
+		if size >= d.MinSize && d.DiscountPercent > discount {
+			discount = d.DiscountPercent
+		}
+	}
+	total = total * (1.0 - float64(discount)/100.0)
+
+	return math.Round(total*100) / 100, nil
+}
+
+// handleActivities serves the activity catalog.
+// GET lists active activities (?all=1 includes inactive, ?slug= fetches one),
+// POST creates, PUT ?slug= updates and DELETE ?slug= deactivates so existing
+// slots and bookings keep their reference.
+func handleActivities(w http.ResponseWriter, r *http.Request) {
+	slug := pathParam(r, "slug")
+
+	switch r.Method {
+	case http.MethodGet:
+		if slug != "" {
+			a, err := getActivityBySlug(db, slug)
+			if err != nil {
+				writeError(w, http.StatusNotFound, "Activity not found")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(a)
+			return
+		}
+
+		activities, err := listActivities(r.URL.Query().Get("all") == "1")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(activities)
+
+	case http.MethodPost, http.MethodPut:
+		var a Activity
+		a.Active = true
+		if !decodeJSON(w, r, &a) {
+			return
+		}
+
+		if r.Method == http.MethodPut {
+			existing, err := getActivityBySlug(db, slug)
+			if err != nil {
+				writeError(w, http.StatusNotFound, "Activity not found")
+				return
+			}
+			a.ID = existing.ID
+			a.Slug = existing.Slug // slugs are referenced by slots and cannot change
+		}
+
+		if err := a.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := validResourceIDs(db, a.ResourceIDs); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := saveActivity(&a); err != nil {
+			if strings.Contains(err.Error(), "UNIQUE") {
+				writeError(w, http.StatusConflict, "Slug already exists: "+a.Slug)
+				return
+			}
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "activity": a})
+
+	case http.MethodDelete:
+		res, err := db.Exec("UPDATE activities SET active = 0 WHERE slug = ?", slug)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, http.StatusNotFound, "Activity not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"os"
+)
+
+// Managing staff needs the farm's admin token (ADMIN_TOKEN) in the
+// X-Admin-Token header. Without ADMIN_TOKEN set those endpoints are closed.
+//
+// Staff changes (uploads, diary posts, stock, customer edits, merges) need
+// the staff member's own API key in the X-Staff-Key header. Only its hash is
+// stored, so the admin hands the key over when it is issued. The calendar feed token is
+// not a credential for anything but the staff member's .ics feed, since it
+// ends up in calendar apps.
+
+// requireAdmin checks the X-Admin-Token header and writes the error response
+// when it doesn't match
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	want := os.Getenv("ADMIN_TOKEN")
+	if want == "" {
+		writeError(w, http.StatusServiceUnavailable, "ADMIN_TOKEN is not set on the server")
+		return false
+	}
+	got := r.Header.Get("X-Admin-Token")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		writeError(w, http.StatusUnauthorized, "Admin token required")
+		return false
+	}
+	return true
+}
+
+// hashStaffKey is how a staff API key is stored
+func hashStaffKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requireStaff checks the X-Staff-Key header against the staff API keys and
+// writes a 401 if it doesn't match. It returns the staff id.
+func requireStaff(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	var id int64
+	key := r.Header.Get("X-Staff-Key")
+	if key == "" || db.QueryRow("SELECT id FROM staff WHERE api_key_hash = ?", hashStaffKey(key)).Scan(&id) != nil {
+		writeError(w, http.StatusUnauthorized, "Staff key required")
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SlotAvailability is a slot with its remaining seats
+type SlotAvailability struct {
+	Slot
+	Remaining  int  `json:"remaining"`
+	SoldOut    bool `json:"soldOut"`
+	NearlyFull bool `json:"nearlyFull"`
+}
+
+// DayAvailability summarises all slots starting on one day
+type DayAvailability struct {
+	Date       string             `json:"date"` // YYYY-MM-DD
+	Capacity   int                `json:"capacity"`
+	Remaining  int                `json:"remaining"`
+	SoldOut    bool               `json:"soldOut"`
+	NearlyFull bool               `json:"nearlyFull"`
+	Slots      []SlotAvailability `json:"slots"`
+}
+
+// isNearlyFull flags slots or days with seats left but at most a fifth of capacity
+func isNearlyFull(remaining, capacity int) bool {
+	return remaining > 0 && remaining*5 <= capacity
+}
+
+// handleAvailability serves the public month-view calendar:
+// GET /api/availability?activity=&from=YYYY-MM-DD&to=YYYY-MM-DD&party=N
+// Dates are farm-local days; "to" is exclusive and defaults to one month after
+// "from". Slots that cannot seat the requested party are left out of the
+// per-day slot list.
+func handleAvailability(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	activity := q.Get("activity")
+
+	from := time.Now().In(farmLocation)
+	if s := q.Get("from"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, farmLocation)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid from date, expected YYYY-MM-DD")
+			return
+		}
+		from = t
+	}
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, farmLocation)
+
+	to := from.AddDate(0, 1, 0)
+	if s := q.Get("to"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, farmLocation)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid to date, expected YYYY-MM-DD")
+			return
+		}
+		to = t
+	}
+	if !to.After(from) {
+		writeError(w, http.StatusBadRequest, "to must be after from")
+		return
+	}
+	if to.Sub(from) > 366*24*time.Hour {
+		writeError(w, http.StatusBadRequest, "Range too large, max one year")
+		return
+	}
+
+	party := 0
+	if s := q.Get("party"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid party size")
+			return
+		}
+		party = n
+	}
+
+	// Seats held for open waitlist offers aren't available to the public
+	query := `SELECT id, activity, start_time, end_time, capacity, booked,
+			(SELECT COALESCE(SUM(party_size), 0) FROM waitlist w
+				WHERE w.slot_id = slots.id AND w.status = 'offered' AND w.offer_expires_at > ?)
+		FROM slots
+		WHERE start_time > CURRENT_TIMESTAMP AND start_time >= ? AND start_time < ? AND private_token IS NULL AND cancelled_at IS NULL`
+	args := []interface{}{dbTime(time.Now()), dbTime(from), dbTime(to)}
+	if activity != "" {
+		query += " AND activity = ?"
+		args = append(args, activity)
+	}
+	query += " ORDER BY start_time ASC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	days := []*DayAvailability{}
+	byDate := map[string]*DayAvailability{}
+	for rows.Next() {
+		var s Slot
+		var held int
+		if err := rows.Scan(&s.ID, &s.Activity, &s.StartTime, &s.EndTime, &s.Capacity, &s.Booked, &held); err != nil {
+			continue
+		}
+
+		remaining := s.Capacity - s.Booked - held
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		// Group by the farm-local day, not the UTC one
+		s.localize()
+		date := s.StartTime
+		if len(date) >= 10 {
+			date = date[:10]
+		}
+		day, ok := byDate[date]
+		if !ok {
+			day = &DayAvailability{Date: date, Slots: []SlotAvailability{}}
+			byDate[date] = day
+			days = append(days, day)
+		}
+		day.Capacity += s.Capacity
+		day.Remaining += remaining
+
+		if party > 0 && remaining < party {
+			continue
+		}
+		day.Slots = append(day.Slots, SlotAvailability{
+			Slot:       s,
+			Remaining:  remaining,
+			SoldOut:    remaining == 0,
+			NearlyFull: isNearlyFull(remaining, s.Capacity),
+		})
+	}
+
+	for _, d := range days {
+		d.SoldOut = d.Remaining == 0
+		d.NearlyFull = isNearlyFull(d.Remaining, d.Capacity)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"activity": activity,
+		"from":     from.Format("2006-01-02"),
+		"to":       to.Format("2006-01-02"),
+		"party":    party,
+		"days":     days,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The ETag is derived from the body, so it changes whenever a booking does
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, no-cache") // always revalidate, seats change
+	if match := r.Header.Get("If-None-Match"); match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
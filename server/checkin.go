@@ -0,0 +1,437 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CheckinBooking is a paid booking as seen at the gate
+type CheckinBooking struct {
+	ID            int64  `json:"id"`
+	SlotID        int64  `json:"slotId"`
+	CustomerName  string `json:"customerName"`
+	CustomerEmail string `json:"customerEmail"`
+	Quantity      int    `json:"quantity"`
+	CheckedIn     int    `json:"checkedIn"`
+	CheckedInAt   string `json:"checkedInAt,omitempty"`
+	NoShow        int    `json:"noShow"`
+	TicketCode    string `json:"ticketCode"`
+	Status        string `json:"status"`
+}
+
+// CheckinSlot is one slot on the arrivals list
+type CheckinSlot struct {
+	Slot
+	Expected int              `json:"expected"` // sum of paid bookings' quantity
+	Arrived  int              `json:"arrived"`
+	NoShows  int              `json:"noShows"`
+	Closed   bool             `json:"closed"` // no-shows have been recorded
+	Bookings []CheckinBooking `json:"bookings"`
+}
+
+func initCheckinTables() {
+	// Migration: tickets and attendance on bookings
+	db.Exec("ALTER TABLE bookings ADD COLUMN ticket_code TEXT")
+	db.Exec("ALTER TABLE bookings ADD COLUMN checked_in INTEGER DEFAULT 0")
+	db.Exec("ALTER TABLE bookings ADD COLUMN checked_in_at DATETIME")
+	db.Exec("ALTER TABLE bookings ADD COLUMN no_show INTEGER DEFAULT 0")
+	db.Exec("ALTER TABLE slots ADD COLUMN attendance_closed_at DATETIME")
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_bookings_ticket_code ON bookings(ticket_code)")
+
+	// Paid bookings for upcoming visits made before tickets existed. Past
+	// visits are left alone so they aren't reported as no-shows.
+	rows, err := db.Query(`
+		SELECT b.id FROM bookings b JOIN slots s ON b.slot_id = s.id
+		WHERE b.status IN ('paid', 'confirmed') AND b.ticket_code IS NULL AND s.end_time > CURRENT_TIMESTAMP`)
+	if err != nil {
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		db.Exec("UPDATE bookings SET ticket_code = ? WHERE id = ?", newTicketCode(), id)
+	}
+}
+
+// ticketAlphabet leaves out 0/O and 1/I/L so codes can be read out loud
+const ticketAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// newTicketCode returns a short code printed under the QR so staff can type
+// it in when a phone screen won't scan
+func newTicketCode() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error generating ticket code: %v", err)
+	}
+	for i := range b {
+		b[i] = ticketAlphabet[int(b[i])%len(ticketAlphabet)]
+	}
+	return string(b)
+}
+
+// normalizeTicketCode accepts codes typed with spaces, dashes or lower case
+func normalizeTicketCode(s string) string {
+	s = strings.ToUpper(s)
+	s = strings.NewReplacer(" ", "", "-", "").Replace(s)
+	return s
+}
+
+// farmDayBounds returns the UTC bounds of the farm-local day containing t
+func farmDayBounds(t time.Time) (time.Time, time.Time) {
+	local := t.In(farmLocation)
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, farmLocation)
+	return start, start.AddDate(0, 0, 1)
+}
+
+const checkinBookingSelectSQL = `SELECT id, slot_id, customer_name, customer_email, quantity, COALESCE(checked_in, 0),
+	COALESCE(checked_in_at, ''), COALESCE(no_show, 0), COALESCE(ticket_code, ''), status FROM bookings`
+
+func scanCheckinBooking(row interface{ Scan(...interface{}) error }) (CheckinBooking, error) {
+	var b CheckinBooking
+	err := row.Scan(&b.ID, &b.SlotID, &b.CustomerName, &b.CustomerEmail, &b.Quantity, &b.CheckedIn,
+		&b.CheckedInAt, &b.NoShow, &b.TicketCode, &b.Status)
+	if b.CheckedInAt != "" {
+		b.CheckedInAt = farmTime(b.CheckedInAt)
+	}
+	return b, err
+}
+
+// arrivalsForDay lists the day's slots with their paid bookings
+func arrivalsForDay(day time.Time) ([]CheckinSlot, error) {
+	from, to := farmDayBounds(day)
+	rows, err := db.Query(`SELECT id, activity, start_time, end_time, capacity, booked, private_token IS NOT NULL, attendance_closed_at IS NOT NULL
+		FROM slots WHERE start_time >= ? AND start_time < ? AND cancelled_at IS NULL ORDER BY start_time ASC`, dbTime(from), dbTime(to))
+	if err != nil {
+		return nil, err
+	}
+	slots := []CheckinSlot{}
+	index := map[int64]int{}
+	for rows.Next() {
+		var cs CheckinSlot
+		if err := rows.Scan(&cs.ID, &cs.Activity, &cs.StartTime, &cs.EndTime, &cs.Capacity, &cs.Booked, &cs.Private, &cs.Closed); err != nil {
+			continue
+		}
+		cs.localize()
+		cs.Bookings = []CheckinBooking{}
+		index[cs.ID] = len(slots)
+		slots = append(slots, cs)
+	}
+	rows.Close()
+
+	rows, err = db.Query(checkinBookingSelectSQL+`
+		WHERE status IN ('paid', 'confirmed') AND slot_id IN (SELECT id FROM slots WHERE start_time >= ? AND start_time < ?)
+		ORDER BY customer_name COLLATE NOCASE ASC`, dbTime(from), dbTime(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		b, err := scanCheckinBooking(rows)
+		if err != nil {
+			continue
+		}
+		i, ok := index[b.SlotID]
+		if !ok {
+			continue
+		}
+		slots[i].Bookings = append(slots[i].Bookings, b)
+		slots[i].Expected += b.Quantity
+		slots[i].Arrived += b.CheckedIn
+		slots[i].NoShows += b.NoShow
+	}
+	return slots, nil
+}
+
+// recordNoShows closes a slot's attendance: every ticketed booking's missing
+// attendees are stored as no-shows. Check-ins after closing update the count.
+func recordNoShows(slotID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE bookings SET no_show = MAX(quantity - COALESCE(checked_in, 0), 0)
+		WHERE slot_id = ? AND status IN ('paid', 'confirmed') AND ticket_code IS NOT NULL`, slotID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("UPDATE slots SET attendance_closed_at = COALESCE(attendance_closed_at, CURRENT_TIMESTAMP) WHERE id = ?", slotID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// closeEndedSlots records no-shows for slots that finished without staff
+// closing them by hand
+func closeEndedSlots() {
+	rows, err := db.Query("SELECT id FROM slots WHERE end_time < CURRENT_TIMESTAMP AND attendance_closed_at IS NULL")
+	if err != nil {
+		log.Printf("Error reading ended slots: %v", err)
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := recordNoShows(id); err != nil {
+			log.Printf("Error recording no-shows for slot %d: %v", id, err)
+		}
+	}
+}
+
+// handleCheckin serves the arrivals list and marks attendees as arrived.
+// GET ?date=YYYY-MM-DD (default today) lists the day's slots and bookings.
+// POST {"code": "...", "bookingId": 0, "checkedIn": N, "force": false} sets how
+// many of a booking's party have arrived, all of them when checkedIn is
+// omitted. Scanning the same ticket twice is harmless. Tickets for another
+// day are refused unless force is set. The list has every visitor's name and
+// email, so both need a staff key.
+func handleCheckin(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		day := time.Now()
+		if s := r.URL.Query().Get("date"); s != "" {
+			t, err := time.ParseInLocation("2006-01-02", s, farmLocation)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid date, expected YYYY-MM-DD")
+				return
+			}
+			day = t
+		}
+		slots, err := arrivalsForDay(day)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"date":  day.In(farmLocation).Format("2006-01-02"),
+			"slots": slots,
+		})
+
+	case http.MethodPost:
+		var req struct {
+			Code      string `json:"code" validate:"max=64"`
+			BookingID int64  `json:"bookingId" validate:"min=0"`
+			CheckedIn *int   `json:"checkedIn" validate:"min=0"`
+			Force     bool   `json:"force"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Code == "" && req.BookingID == 0 {
+			writeFieldErrors(w, fieldErrors{"code": "is required without a bookingId"})
+			return
+		}
+
+		var row *sql.Row
+		if code := normalizeTicketCode(req.Code); code != "" {
+			row = db.QueryRow(checkinBookingSelectSQL+" WHERE ticket_code = ?", code)
+		} else {
+			row = db.QueryRow(checkinBookingSelectSQL+" WHERE id = ?", req.BookingID)
+		}
+		b, err := scanCheckinBooking(row)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Ticket not found")
+			return
+		}
+
+		var activity, startStr string
+		var closed bool
+		db.QueryRow("SELECT activity, start_time, attendance_closed_at IS NOT NULL FROM slots WHERE id = ?", b.SlotID).Scan(&activity, &startStr, &closed)
+		start, _ := parseSlotTime(startStr)
+
+		reject := func(message string, wrongDay bool) {
+			writeErrorDetails(w, http.StatusConflict, "checkin_rejected", message,
+				map[string]interface{}{"wrongDay": wrongDay, "booking": b})
+		}
+		if b.Status != "paid" && b.Status != "confirmed" {
+			reject(fmt.Sprintf("Booking #%d is %s, not paid", b.ID, b.Status), false)
+			return
+		}
+		today, _ := farmDayBounds(time.Now())
+		slotDay, _ := farmDayBounds(start)
+		if !slotDay.Equal(today) && !req.Force {
+			reject(fmt.Sprintf("Ticket is for %s on %s", activityName(activity), start.In(farmLocation).Format("2006-01-02 15:04")), true)
+			return
+		}
+
+		count := b.Quantity
+		if req.CheckedIn != nil {
+			count = *req.CheckedIn
+		}
+		if count < 0 || count > b.Quantity {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("checkedIn must be between 0 and %d", b.Quantity))
+			return
+		}
+
+		noShow := 0
+		if closed {
+			noShow = b.Quantity - count
+		}
+		_, err = db.Exec(`UPDATE bookings SET checked_in = ?, no_show = ?,
+			checked_in_at = CASE WHEN ? > 0 THEN COALESCE(checked_in_at, CURRENT_TIMESTAMP) ELSE NULL END
+			WHERE id = ?`, count, noShow, count, b.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		log.Printf("🎟️  Check-in: booking #%d (%s) %d/%d arrived", b.ID, b.CustomerName, count, b.Quantity)
+
+		b, _ = scanCheckinBooking(db.QueryRow(checkinBookingSelectSQL+" WHERE id = ?", b.ID))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"booking":   b,
+			"activity":  activityName(activity),
+			"startTime": start.In(farmLocation).Format(time.RFC3339),
+		})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleCheckinClose records no-shows for a slot now rather than waiting for
+// it to end. POST {"slotId": N}. Needs a staff key.
+func handleCheckinClose(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+	var req struct {
+		SlotID int64 `json:"slotId" validate:"required"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var exists int
+	if db.QueryRow("SELECT 1 FROM slots WHERE id = ?", req.SlotID).Scan(&exists) != nil {
+		writeError(w, http.StatusNotFound, "Slot not found")
+		return
+	}
+	if err := recordNoShows(req.SlotID); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// handleCheckinReport summarises attendance per activity for closed slots.
+// GET /api/checkin/report?from=YYYY-MM-DD&to=YYYY-MM-DD (default last 30 days)
+func handleCheckinReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	to, _ := farmDayBounds(time.Now())
+	to = to.AddDate(0, 0, 1)
+	from := to.AddDate(0, 0, -30)
+	for name, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		if s := q.Get(name); s != "" {
+			t, err := time.ParseInLocation("2006-01-02", s, farmLocation)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid "+name+" date, expected YYYY-MM-DD")
+				return
+			}
+			*dst = t
+		}
+	}
+
+	rows, err := db.Query(`
+		SELECT s.activity, COUNT(DISTINCT s.id), COALESCE(SUM(b.quantity), 0), COALESCE(SUM(b.checked_in), 0), COALESCE(SUM(b.no_show), 0)
+		FROM slots s JOIN bookings b ON b.slot_id = s.id
+		WHERE s.attendance_closed_at IS NOT NULL AND s.start_time >= ? AND s.start_time < ?
+			AND b.status IN ('paid', 'confirmed') AND b.ticket_code IS NOT NULL
+		GROUP BY s.activity ORDER BY s.activity`, dbTime(from), dbTime(to))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	type activityAttendance struct {
+		Activity   string  `json:"activity"`
+		Slots      int     `json:"slots"`
+		Booked     int     `json:"booked"`
+		Arrived    int     `json:"arrived"`
+		NoShows    int     `json:"noShows"`
+		NoShowRate float64 `json:"noShowRate"`
+	}
+	report := []activityAttendance{}
+	for rows.Next() {
+		var a activityAttendance
+		if err := rows.Scan(&a.Activity, &a.Slots, &a.Booked, &a.Arrived, &a.NoShows); err != nil {
+			continue
+		}
+		if a.Booked > 0 {
+			a.NoShowRate = float64(a.NoShows) / float64(a.Booked)
+		}
+		report = append(report, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":       from.Format("2006-01-02"),
+		"to":         to.Format("2006-01-02"),
+		"activities": report,
+	})
+}
+
+// handleBookingTicket returns what the visitor's ticket page shows.
+// GET /api/bookings/ticket?token=<booking access token>
+func handleBookingTicket(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "Missing token")
+		return
+	}
+
+	var b CheckinBooking
+	var activity, startStr string
+	err := db.QueryRow(`
+		SELECT b.id, b.customer_name, b.quantity, b.status, COALESCE(b.ticket_code, ''), s.activity, s.start_time
+		FROM bookings b JOIN slots s ON b.slot_id = s.id
+		WHERE b.access_token = ?`, token).Scan(&b.ID, &b.CustomerName, &b.Quantity, &b.Status, &b.TicketCode, &activity, &startStr)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Booking not found")
+		return
+	}
+	if b.TicketCode == "" {
+		writeError(w, http.StatusConflict, "Tickets are issued once the booking is paid")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"bookingId":    b.ID,
+		"customerName": b.CustomerName,
+		"quantity":     b.Quantity,
+		"status":       b.Status,
+		"ticketCode":   b.TicketCode,
+		"activity":     activityNameIn(activity, requestLocale(r)),
+		"startTime":    farmTime(startStr),
+	})
+}
+
+func handleAdminCheckin(w http.ResponseWriter, r *http.Request) {
+	tmpl.ExecuteTemplate(w, "admin-checkin.html", nil)
+}
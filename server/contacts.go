@@ -0,0 +1,579 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// A contact is one person, whichever way they reached the farm: adopting a
+// tree, booking a visit, ordering from the shop, asking about a private
+// visit, joining a waitlist or answering a survey. Each of those rows has a
+// contact_id, found by its email address trimmed and lowercased.
+//
+// contact_emails maps every address a contact has used to them. Merging two
+// contacts that turn out to be the same person moves the other's addresses
+// and rows over, so new rows under an old address still end up together.
+// contact_merges records each merge with the rows it moved, so staff can
+// undo one that joined two different people.
+
+// Contact is a person and the addresses they have used
+type Contact struct {
+	ID        int64          `json:"id"`
+	Email     string         `json:"email"`
+	Name      string         `json:"name"`
+	Emails    []string       `json:"emails"`
+	Links     map[string]int `json:"links"` // rows per table, e.g. {"bookings": 2}
+	CreatedAt string         `json:"createdAt"`
+}
+
+// contactSources are the tables whose rows belong to a contact, with the
+// columns holding the address and name they were made with. Rows in emails
+// and activity_log are linked too but never create a contact.
+var contactSources = []struct{ table, email, name string }{
+	{"customers", "email", "name"},
+	{"bookings", "customer_email", "customer_name"},
+	{"orders", "customer_email", "customer_name"},
+	{"inquiries", "email", "name"},
+	{"waitlist", "email", "name"},
+	{"feedback", "email", "''"},
+}
+
+// contactLinkedTables are every table with a contact_id column
+var contactLinkedTables = []string{"customers", "bookings", "orders", "inquiries", "waitlist", "feedback", "emails", "activity_log"}
+
+// ContactMerge is one contact merged into another
+type ContactMerge struct {
+	ID        int64             `json:"id"`
+	IntoID    int64             `json:"intoId"`
+	FromID    int64             `json:"fromId"`
+	FromEmail string            `json:"fromEmail"`
+	FromName  string            `json:"fromName"`
+	Moved     contactMergeMoved `json:"moved"`
+	StaffName string            `json:"staffName"` // empty when the system merged, e.g. on a confirmed new address
+	CreatedAt string            `json:"createdAt"`
+	UndoneAt  *string           `json:"undoneAt"`
+}
+
+// contactMergeMoved is what a merge moved from the merged contact: row ids
+// per table in contactLinkedTables, and its addresses
+type contactMergeMoved struct {
+	Rows   map[string][]int64 `json:"rows"`
+	Emails []string           `json:"emails"`
+}
+
+// execer is a queryer that can also write, *sql.DB or *sql.Tx
+type execer interface {
+	queryer
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// initContactTables runs after the other init*Tables, since it adds a
+// column to each of them. The backfill links every row that has no contact
+// yet, so it is safe to run on each start.
+func initContactTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS contacts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT UNIQUE,
+		name TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS contact_emails (
+		email TEXT PRIMARY KEY,
+		contact_id INTEGER REFERENCES contacts(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_contact_emails_contact ON contact_emails(contact_id);
+	CREATE TABLE IF NOT EXISTS contact_merges (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		into_id INTEGER,
+		from_id INTEGER,
+		from_email TEXT,
+		from_name TEXT DEFAULT '',
+		from_created_at DATETIME,
+		into_name TEXT DEFAULT '',
+		moved TEXT,
+		staff_id INTEGER REFERENCES staff(id),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		undone_at DATETIME,
+		undone_by INTEGER REFERENCES staff(id)
+	);`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating contact tables: %v", err)
+		return
+	}
+
+	// Migrations: link every entity to its contact
+	for _, table := range contactLinkedTables {
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN contact_id INTEGER REFERENCES contacts(id)")
+		db.Exec("CREATE INDEX IF NOT EXISTS idx_" + table + "_contact ON " + table + "(contact_id)")
+	}
+
+	// Backfill: a contact per address not seen before, named from its
+	// first row, customers first
+	var before, after int
+	db.QueryRow("SELECT COUNT(*) FROM contacts").Scan(&before)
+	for _, src := range contactSources {
+		_, err := db.Exec(fmt.Sprintf(`INSERT OR IGNORE INTO contacts (email, name)
+			SELECT lower(trim(%[2]s)), COALESCE(%[3]s, '') FROM %[1]s
+			WHERE trim(COALESCE(%[2]s, '')) != '' AND lower(trim(%[2]s)) NOT IN (SELECT email FROM contact_emails)
+			ORDER BY id`, src.table, src.email, src.name))
+		if err == nil {
+			_, err = db.Exec("INSERT OR IGNORE INTO contact_emails (email, contact_id) SELECT email, id FROM contacts")
+		}
+		if err == nil {
+			_, err = db.Exec(fmt.Sprintf(`UPDATE %[1]s SET contact_id = (SELECT contact_id FROM contact_emails WHERE email = lower(trim(%[1]s.%[2]s)))
+				WHERE contact_id IS NULL AND trim(COALESCE(%[2]s, '')) != ''`, src.table, src.email))
+		}
+		if err != nil {
+			log.Printf("Error linking %s to contacts: %v", src.table, err)
+		}
+	}
+	db.Exec(`UPDATE emails SET contact_id = (SELECT contact_id FROM contact_emails WHERE email = lower(trim(emails.to_email)))
+		WHERE contact_id IS NULL`)
+	db.Exec(`UPDATE activity_log SET contact_id = (SELECT contact_id FROM customers WHERE customers.id = activity_log.customer_id)
+		WHERE contact_id IS NULL AND customer_id IS NOT NULL`)
+	db.QueryRow("SELECT COUNT(*) FROM contacts").Scan(&after)
+	if after > before {
+		log.Printf("👥 Created %d contacts from existing adopters, visitors and inquiries", after-before)
+	}
+}
+
+// contactIDFor finds the contact using an address, creating it when the
+// address is new, and returns 0 for an empty address. Pass the transaction
+// when there is one, as SQLite allows only one writer.
+func contactIDFor(q execer, email, name string) (int64, error) {
+	email = normalizeEmail(email)
+	if email == "" {
+		return 0, nil
+	}
+	var id int64
+	err := q.QueryRow("SELECT contact_id FROM contact_emails WHERE email = ?", email).Scan(&id)
+	if err == sql.ErrNoRows {
+		res, err := q.Exec("INSERT INTO contacts (email, name) VALUES (?, ?)", email, strings.TrimSpace(name))
+		if err != nil {
+			return 0, err
+		}
+		id, _ = res.LastInsertId()
+		_, err = q.Exec("INSERT INTO contact_emails (email, contact_id) VALUES (?, ?)", email, id)
+		return id, err
+	} else if err != nil {
+		return 0, err
+	}
+	if name = strings.TrimSpace(name); name != "" {
+		q.Exec("UPDATE contacts SET name = ? WHERE id = ? AND name = ''", name, id)
+	}
+	return id, nil
+}
+
+// moveContactEmail is a person confirming they now use another address: the
+// new address joins their contact and becomes its main one. When it already
+// belongs to someone else, the two are the same person and get merged.
+func moveContactEmail(oldEmail, newEmail string) error {
+	from, err := contactIDFor(db, oldEmail, "")
+	if err != nil {
+		return err
+	}
+	var to int64
+	err = db.QueryRow("SELECT contact_id FROM contact_emails WHERE email = ?", normalizeEmail(newEmail)).Scan(&to)
+	if err == nil {
+		if to != from {
+			return mergeContacts(to, []int64{from}, 0)
+		}
+		_, err = db.Exec("UPDATE contacts SET email = ? WHERE id = ?", normalizeEmail(newEmail), to)
+		return err
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	if _, err := db.Exec("INSERT INTO contact_emails (email, contact_id) VALUES (?, ?)", normalizeEmail(newEmail), from); err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE contacts SET email = ? WHERE id = ?", normalizeEmail(newEmail), from)
+	return err
+}
+
+// mergeContacts moves the rows and addresses of the from contacts to into
+// and deletes them. Each one is recorded in contact_merges for undoing; a
+// staffID of 0 means the system merged them.
+func mergeContacts(into int64, from []int64, staffID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var intoName string
+	if err := tx.QueryRow("SELECT COALESCE(name, '') FROM contacts WHERE id = ?", into).Scan(&intoName); err != nil {
+		return err
+	}
+	for _, id := range from {
+		moved, err := contactRows(tx, id)
+		if err != nil {
+			return err
+		}
+		movedJSON, _ := json.Marshal(moved)
+		if _, err := tx.Exec(`INSERT INTO contact_merges (into_id, from_id, from_email, from_name, from_created_at, into_name, moved, staff_id)
+			SELECT ?, id, email, COALESCE(name, ''), created_at, ?, ?, NULLIF(?, 0) FROM contacts WHERE id = ?`,
+			into, intoName, string(movedJSON), staffID, id); err != nil {
+			return err
+		}
+	}
+
+	placeholders := "?" + strings.Repeat(", ?", len(from)-1)
+	ids := make([]interface{}, len(from))
+	for i, id := range from {
+		ids[i] = id
+	}
+	moveArgs := append([]interface{}{into}, ids...)
+	for _, table := range append(contactLinkedTables, "contact_emails") {
+		if _, err := tx.Exec("UPDATE "+table+" SET contact_id = ? WHERE contact_id IN ("+placeholders+")", moveArgs...); err != nil {
+			return err
+		}
+	}
+	nameArgs := append(append([]interface{}{}, ids...), into)
+	if _, err := tx.Exec(`UPDATE contacts SET name = COALESCE((SELECT name FROM contacts WHERE id IN (`+placeholders+`) AND name != '' ORDER BY id LIMIT 1), '')
+		WHERE id = ? AND name = ''`, nameArgs...); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM contacts WHERE id IN ("+placeholders+")", ids...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// contactRows lists the rows and addresses that belong to a contact
+func contactRows(q queryer, contactID int64) (contactMergeMoved, error) {
+	moved := contactMergeMoved{Rows: map[string][]int64{}, Emails: []string{}}
+	for _, table := range contactLinkedTables {
+		rows, err := q.Query("SELECT id FROM "+table+" WHERE contact_id = ? ORDER BY id", contactID)
+		if err != nil {
+			return moved, err
+		}
+		for rows.Next() {
+			var id int64
+			rows.Scan(&id)
+			moved.Rows[table] = append(moved.Rows[table], id)
+		}
+		rows.Close()
+	}
+	rows, err := q.Query("SELECT email FROM contact_emails WHERE contact_id = ? ORDER BY email", contactID)
+	if err != nil {
+		return moved, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var email string
+		rows.Scan(&email)
+		moved.Emails = append(moved.Emails, email)
+	}
+	return moved, rows.Err()
+}
+
+// errMergeNotUndoable is undoContactMerge refusing, with the reason
+type errMergeNotUndoable string
+
+func (e errMergeNotUndoable) Error() string { return string(e) }
+
+// undoContactMerge brings a merged contact back under its old id and moves
+// back the rows and addresses the merge moved, as long as they are still on
+// the contact it was merged into. Rows added to that contact since stay.
+func undoContactMerge(mergeID, staffID int64) (*ContactMerge, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var m ContactMerge
+	var intoName, moved string
+	err = tx.QueryRow(`SELECT id, into_id, from_id, from_email, from_name, into_name, moved, undone_at FROM contact_merges WHERE id = ?`, mergeID).
+		Scan(&m.ID, &m.IntoID, &m.FromID, &m.FromEmail, &m.FromName, &intoName, &moved, &m.UndoneAt)
+	if err != nil {
+		return nil, err
+	}
+	if m.UndoneAt != nil {
+		return nil, errMergeNotUndoable("The merge has already been undone")
+	}
+	json.Unmarshal([]byte(moved), &m.Moved)
+
+	var n int
+	tx.QueryRow("SELECT COUNT(*) FROM contacts WHERE id = ?", m.IntoID).Scan(&n)
+	if n == 0 {
+		return nil, errMergeNotUndoable(fmt.Sprintf("Contact #%d has since been merged into another contact; undo that merge first", m.IntoID))
+	}
+	var owner int64
+	if tx.QueryRow("SELECT id FROM contacts WHERE id = ? OR email = ?", m.FromID, m.FromEmail).Scan(&owner) == nil {
+		return nil, errMergeNotUndoable(fmt.Sprintf("%s is now the main address of contact #%d", m.FromEmail, owner))
+	}
+
+	if _, err := tx.Exec(`INSERT INTO contacts (id, email, name, created_at)
+		SELECT from_id, from_email, from_name, from_created_at FROM contact_merges WHERE id = ?`, mergeID); err != nil {
+		return nil, err
+	}
+	for table, ids := range m.Moved.Rows {
+		for _, id := range ids {
+			if _, err := tx.Exec("UPDATE "+table+" SET contact_id = ? WHERE id = ? AND contact_id = ?", m.FromID, id, m.IntoID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, email := range m.Moved.Emails {
+		if _, err := tx.Exec("UPDATE contact_emails SET contact_id = ? WHERE email = ? AND contact_id = ?", m.FromID, email, m.IntoID); err != nil {
+			return nil, err
+		}
+	}
+	// The merge named an unnamed contact after the merged one
+	if intoName == "" {
+		if _, err := tx.Exec("UPDATE contacts SET name = '' WHERE id = ? AND name = ?", m.IntoID, m.FromName); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec("UPDATE contact_merges SET undone_at = CURRENT_TIMESTAMP, undone_by = NULLIF(?, 0) WHERE id = ?", staffID, mergeID); err != nil {
+		return nil, err
+	}
+	return &m, tx.Commit()
+}
+
+func getContact(id int64) (*Contact, error) {
+	c := Contact{Links: map[string]int{}}
+	err := db.QueryRow("SELECT id, email, COALESCE(name, ''), created_at FROM contacts WHERE id = ?", id).
+		Scan(&c.ID, &c.Email, &c.Name, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT email FROM contact_emails WHERE contact_id = ? ORDER BY email", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var email string
+		rows.Scan(&email)
+		c.Emails = append(c.Emails, email)
+	}
+
+	for _, table := range contactLinkedTables {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE contact_id = ?", id).Scan(&n)
+		if n > 0 {
+			c.Links[table] = n
+		}
+	}
+	return &c, nil
+}
+
+// contactDuplicateRules find contacts that may be one person under several
+// addresses: the same name, or the same part before the @
+var contactDuplicateRules = []struct{ reason, key string }{
+	{"name", "lower(trim(name))"},
+	{"email", "substr(email, 1, instr(email, '@') - 1)"},
+}
+
+// handleContactDuplicates lists groups of contacts that may be the same
+// person, for staff to merge: GET /api/contacts/duplicates
+func handleContactDuplicates(w http.ResponseWriter, r *http.Request) {
+	type group struct {
+		Reason   string     `json:"reason"`
+		Key      string     `json:"key"`
+		Contacts []*Contact `json:"contacts"`
+	}
+	groups := []group{}
+	groupOf := map[int64]int{} // contact id → index in groups, plus one
+	for _, rule := range contactDuplicateRules {
+		rows, err := db.Query(fmt.Sprintf(`SELECT %[1]s, group_concat(id) FROM (SELECT id, email, name FROM contacts ORDER BY id)
+			WHERE %[1]s != '' GROUP BY %[1]s HAVING COUNT(*) > 1 ORDER BY %[1]s`, rule.key))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		var found []group
+		var idLists [][]int64
+		for rows.Next() {
+			g := group{Reason: rule.reason}
+			var ids string
+			rows.Scan(&g.Key, &ids)
+			var list []int64
+			for _, s := range strings.Split(ids, ",") {
+				id, _ := strconv.ParseInt(s, 10, 64)
+				list = append(list, id)
+			}
+			found = append(found, g)
+			idLists = append(idLists, list)
+		}
+		rows.Close()
+
+		for i, g := range found {
+			// Skip contacts an earlier group already shows together
+			same := groupOf[idLists[i][0]] != 0
+			for _, id := range idLists[i] {
+				same = same && groupOf[id] == groupOf[idLists[i][0]]
+			}
+			if same {
+				continue
+			}
+			for _, id := range idLists[i] {
+				c, err := getContact(id)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err.Error())
+					return
+				}
+				g.Contacts = append(g.Contacts, c)
+				if groupOf[id] == 0 {
+					groupOf[id] = len(groups) + 1
+				}
+			}
+			groups = append(groups, g)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// handleContact returns a contact with its addresses and linked rows:
+// GET /api/contacts/{id}
+func handleContact(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	c, err := getContact(id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "Contact not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// handleMergeContacts folds duplicates into one contact:
+// POST /api/contacts/{id}/merge {"contactIds": [12, 31]}. Needs a staff key.
+// Each merged contact can be brought back with handleUndoContactMerge.
+func handleMergeContacts(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		ContactIDs []int64 `json:"contactIds" validate:"required,max=50"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	into, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if _, err := getContact(into); err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "Contact not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var emails []string
+	for _, id := range req.ContactIDs {
+		if id == into {
+			writeFieldErrors(w, fieldErrors{"contactIds": "cannot include the contact merged into"})
+			return
+		}
+		c, err := getContact(id)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Contact #%d not found", id))
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		emails = append(emails, c.Emails...)
+	}
+
+	if err := mergeContacts(into, req.ContactIDs, staffID); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logContactActivity(into, staffID, "merged", "Merged in "+strings.Join(emails, ", "))
+	log.Printf("🔗 Contact #%d merged with %v (%s)", into, req.ContactIDs, strings.Join(emails, ", "))
+
+	c, err := getContact(into)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "contact": c})
+}
+
+// handleContactMerges lists the latest merges, newest first, for staff to
+// check and undo: GET /api/contacts/merges
+func handleContactMerges(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`SELECT m.id, m.into_id, m.from_id, m.from_email, m.from_name, m.moved, COALESCE(s.name, ''), m.created_at, m.undone_at
+		FROM contact_merges m LEFT JOIN staff s ON s.id = m.staff_id
+		ORDER BY m.id DESC LIMIT 50`)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	merges := []ContactMerge{}
+	for rows.Next() {
+		var m ContactMerge
+		var moved string
+		if err := rows.Scan(&m.ID, &m.IntoID, &m.FromID, &m.FromEmail, &m.FromName, &moved, &m.StaffName, &m.CreatedAt, &m.UndoneAt); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		json.Unmarshal([]byte(moved), &m.Moved)
+		merges = append(merges, m)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(merges)
+}
+
+// handleUndoContactMerge splits a merged contact off again:
+// POST /api/contacts/merges/{id}/undo. Needs a staff key.
+func handleUndoContactMerge(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	m, err := undoContactMerge(id, staffID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "Merge not found")
+		return
+	} else if _, refused := err.(errMergeNotUndoable); refused {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logContactActivity(m.IntoID, staffID, "unmerged", fmt.Sprintf("Split off %s again (contact #%d)", m.FromEmail, m.FromID))
+	logContactActivity(m.FromID, staffID, "unmerged", fmt.Sprintf("Split off again from contact #%d", m.IntoID))
+	log.Printf("✂️ Contact merge #%d undone: #%d (%s) split off from #%d", m.ID, m.FromID, m.FromEmail, m.IntoID)
+
+	c, err := getContact(m.FromID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "contact": c})
+}
+
+// logContactActivity is logActivity for a person who may not have adopted a
+// tree, e.g. a visitor. A staffID of 0 means the system did it.
+func logContactActivity(contactID, staffID int64, action, message string) {
+	if staffID != 0 {
+		var name string
+		db.QueryRow("SELECT name FROM staff WHERE id = ?", staffID).Scan(&name)
+		message = name + ": " + message
+	}
+	db.Exec("INSERT INTO activity_log (contact_id, action, message, staff_id) VALUES (NULLIF(?, 0), ?, ?, NULLIF(?, 0))",
+		contactID, action, message, staffID)
+}
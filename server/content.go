@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Content keys are the ids of the editable fields in content-admin.html
+var contentKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+func initContentTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS site_content (
+		key TEXT PRIMARY KEY,
+		value TEXT,
+		last_updated DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS site_content_translations (
+		key TEXT,
+		locale TEXT,
+		value TEXT,
+		last_updated DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (key, locale)
+	);`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating content tables: %v", err)
+	}
+}
+
+// loadSiteContent returns every saved CMS field by key. With a locale, the
+// texts written for that language replace the default ones.
+func loadSiteContent(locale string) (map[string]string, error) {
+	content, err := querySiteContent("SELECT key, value FROM site_content")
+	if err != nil || locale == "" {
+		return content, err
+	}
+	translated, err := querySiteContent("SELECT key, value FROM site_content_translations WHERE locale = ?", locale)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range translated {
+		content[key] = value
+	}
+	return content, nil
+}
+
+func querySiteContent(query string, args ...interface{}) (map[string]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	content := map[string]string{}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		content[key] = value
+	}
+	return content, nil
+}
+
+// handleContent stores the CMS fields.
+// GET returns all fields, with ?locale= as that language sees them and with
+// ?locale=&only=1 just the texts written for it. PUT {"key", "value"} saves
+// the default text, {"key", "value", "locale"} one language's own text (an
+// empty value falls back to the default again). Values are HTML and go
+// through the same sanitizer as newsletters.
+func handleContent(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var content map[string]string
+		var err error
+		locale := normalizeLocale(r.URL.Query().Get("locale"))
+		if locale != "" && r.URL.Query().Get("only") == "1" {
+			content, err = querySiteContent("SELECT key, value FROM site_content_translations WHERE locale = ?", locale)
+		} else {
+			content, err = loadSiteContent(locale)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(content)
+
+	case http.MethodPut:
+		var req struct {
+			Key    string `json:"key" validate:"required,max=64"`
+			Value  string `json:"value" validate:"max=20000"`
+			Locale string `json:"locale" validate:"locale"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if !contentKeyPattern.MatchString(req.Key) {
+			writeFieldErrors(w, fieldErrors{"key": "must be lowercase letters, digits and underscores"})
+			return
+		}
+		locale := req.Locale
+		clean, stripped := sanitizeHTML(req.Value)
+		if len(stripped) > 0 {
+			writeErrorDetails(w, http.StatusBadRequest, "disallowed_html", strippedError("The text", stripped),
+				map[string]interface{}{"stripped": stripped})
+			return
+		}
+		var err error
+		switch {
+		case locale == "":
+			_, err = db.Exec("INSERT INTO site_content (key, value, last_updated) VALUES (?, ?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value, last_updated = excluded.last_updated",
+				req.Key, clean, dbTime(time.Now()))
+		case strings.TrimSpace(clean) == "":
+			_, err = db.Exec("DELETE FROM site_content_translations WHERE key = ? AND locale = ?", req.Key, locale)
+		default:
+			_, err = db.Exec(`INSERT INTO site_content_translations (key, locale, value, last_updated) VALUES (?, ?, ?, ?)
+				ON CONFLICT(key, locale) DO UPDATE SET value = excluded.value, last_updated = excluded.last_updated`,
+				req.Key, locale, clean, dbTime(time.Now()))
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if locale != "" {
+			log.Printf("✏️ Content updated: %s (%s)", req.Key, locale)
+		} else {
+			log.Printf("✏️ Content updated: %s", req.Key)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "value": clean})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
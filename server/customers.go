@@ -0,0 +1,353 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Staff browse customers page by page and edit or delete them. Every change
+// is written to activity_log with the staff member who made it, next to the
+// automation events, so an adoption's history stays in one place.
+
+const customerColumns = `id, COALESCE(name, ''), COALESCE(email, ''), COALESCE(phone, ''), COALESCE(country, ''),
+	COALESCE(tree_type, ''), COALESCE(years, 1), COALESCE(is_gift, 0), COALESCE(promo_code, ''), COALESCE(amount_paid, 0),
+	COALESCE(status, ''), COALESCE(newsletter_stage, 'none'), COALESCE(locale, ''), COALESCE(contact_id, 0), created_at`
+
+const (
+	defaultCustomerPageSize = 50
+	maxCustomerPageSize     = 200
+)
+
+// customerSorts are the orders the list can be sorted in, "-" in front for
+// descending. created_at is compared as text so the page cursor round-trips
+// exactly instead of through the driver's time parsing.
+var customerSorts = map[string]string{
+	"created": "CAST(created_at AS TEXT)",
+	"name":    "lower(COALESCE(name, ''))",
+	"amount":  "COALESCE(amount_paid, 0)",
+}
+
+// customerCursor is where the next page starts: the sort value and id of
+// the last customer on this page
+type customerCursor struct {
+	Sort  string      `json:"s"`
+	Value interface{} `json:"v"`
+	ID    int64       `json:"id"`
+}
+
+func (c customerCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCustomerCursor(s string) (customerCursor, error) {
+	var c customerCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	return c, err
+}
+
+func initCustomerTables() {
+	// Migrations: who made a change, for staff edits in the audit trail
+	db.Exec("ALTER TABLE activity_log ADD COLUMN staff_id INTEGER")
+}
+
+func scanCustomer(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*Customer, error) {
+	var c Customer
+	dest := []interface{}{&c.ID, &c.Name, &c.Email, &c.Phone, &c.Country, &c.TreeType, &c.Years, &c.IsGift, &c.PromoCode,
+		&c.AmountPaid, &c.Status, &c.NewsletterStage, &c.Locale, &c.ContactID, &c.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func getCustomer(id int64) (*Customer, error) {
+	return scanCustomer(db.QueryRow("SELECT "+customerColumns+" FROM customers WHERE id = ?", id))
+}
+
+// handleCustomers lists customers with filters and cursor pagination:
+// GET /api/customers?status=paid,subscribed&treeType=&country=&q=&sort=-created&limit=50&page=<nextPage>
+func handleCustomers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	sort := query.Get("sort")
+	if sort == "" {
+		sort = "-created"
+	}
+	desc := strings.HasPrefix(sort, "-")
+	expr, ok := customerSorts[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		writeFieldErrors(w, fieldErrors{"sort": "must be one of created, name, amount, optionally with - in front"})
+		return
+	}
+
+	limit := defaultCustomerPageSize
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxCustomerPageSize {
+			writeFieldErrors(w, fieldErrors{"limit": fmt.Sprintf("must be a number from 1 to %d", maxCustomerPageSize)})
+			return
+		}
+		limit = n
+	}
+
+	// Filters, shared by the page and the total count
+	var where []string
+	var args []interface{}
+	if v := query.Get("status"); v != "" {
+		statuses := strings.Split(v, ",")
+		where = append(where, "status IN (?"+strings.Repeat(", ?", len(statuses)-1)+")")
+		for _, s := range statuses {
+			args = append(args, strings.TrimSpace(s))
+		}
+	}
+	if v := strings.TrimSpace(query.Get("treeType")); v != "" {
+		where = append(where, "lower(tree_type) = lower(?)")
+		args = append(args, v)
+	}
+	if v := strings.TrimSpace(query.Get("country")); v != "" {
+		where = append(where, "lower(country) = lower(?)")
+		args = append(args, v)
+	}
+	if v := strings.TrimSpace(query.Get("q")); v != "" {
+		like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(v)) + "%"
+		where = append(where, `(lower(name) LIKE ? ESCAPE '\' OR lower(email) LIKE ? ESCAPE '\')`)
+		args = append(args, like, like)
+	}
+	filter := ""
+	if len(where) > 0 {
+		filter = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM customers"+filter, args...).Scan(&total); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Keyset pagination: continue after the last row of the previous page,
+	// with the id breaking ties between equal sort values
+	cmp, dir := ">", "ASC"
+	if desc {
+		cmp, dir = "<", "DESC"
+	}
+	pageWhere, pageArgs := where, args
+	if v := query.Get("page"); v != "" {
+		cursor, err := decodeCustomerCursor(v)
+		if err != nil || cursor.Sort != sort {
+			writeFieldErrors(w, fieldErrors{"page": "must be the nextPage of a previous page with the same sort"})
+			return
+		}
+		pageWhere = append(append([]string{}, where...), fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", expr, cmp, expr, cmp))
+		pageArgs = append(append([]interface{}{}, args...), cursor.Value, cursor.Value, cursor.ID)
+	}
+	sqlText := "SELECT " + customerColumns + ", " + expr + " FROM customers"
+	if len(pageWhere) > 0 {
+		sqlText += " WHERE " + strings.Join(pageWhere, " AND ")
+	}
+	sqlText += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT ?", expr, dir, dir)
+
+	rows, err := db.Query(sqlText, append(pageArgs, limit+1)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	customers := []*Customer{}
+	var last interface{}
+	more := false
+	for rows.Next() {
+		// One row more than the limit means there is another page
+		if len(customers) == limit {
+			more = true
+			break
+		}
+		var sortValue interface{}
+		c, err := scanCustomer(rows, &sortValue)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if b, ok := sortValue.([]byte); ok {
+			sortValue = string(b)
+		}
+		customers = append(customers, c)
+		last = sortValue
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := map[string]interface{}{"customers": customers, "total": total, "nextPage": nil}
+	if more {
+		resp["nextPage"] = customerCursor{Sort: sort, Value: last, ID: customers[len(customers)-1].ID}.encode()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleCustomer reads, edits or deletes one customer:
+// GET, PATCH {"status": "paid", "years": 2, ...} or DELETE /api/customers/{id}.
+// Changes need a staff API key (see auth.go) and are logged with the old and
+// new values under the staff member the key belongs to.
+func handleCustomer(w http.ResponseWriter, r *http.Request) {
+	var staffID int64
+	if r.Method != http.MethodGet {
+		var ok bool
+		if staffID, ok = requireStaff(w, r); !ok {
+			return
+		}
+	}
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	c, err := getCustomer(id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "Customer not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+
+	case http.MethodPatch:
+		// Fields left out stay as they are
+		var req struct {
+			Name            *string  `json:"name" validate:"required,max=200"`
+			Email           *string  `json:"email" validate:"required,email"`
+			Phone           *string  `json:"phone" validate:"max=40"`
+			Country         *string  `json:"country" validate:"max=100"`
+			TreeType        *string  `json:"treeType" validate:"required,variety"`
+			Years           *int     `json:"years" validate:"min=1,max=10"`
+			IsGift          *bool    `json:"isGift"`
+			PromoCode       *string  `json:"promoCode" validate:"max=64"`
+			AmountPaid      *float64 `json:"amountPaid" validate:"min=0"`
+			Status          *string  `json:"status" validate:"required,oneof=interested|paid|email_sent|subscribed"`
+			NewsletterStage *string  `json:"newsletterStage" validate:"required,oneof=none|welcome|monthly"`
+			Locale          *string  `json:"locale" validate:"locale"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		updated := *c
+		setString := func(dst *string, src *string) {
+			if src != nil {
+				*dst = strings.TrimSpace(*src)
+			}
+		}
+		setString(&updated.Name, req.Name)
+		setString(&updated.Email, req.Email)
+		setString(&updated.Phone, req.Phone)
+		setString(&updated.Country, req.Country)
+		setString(&updated.TreeType, req.TreeType)
+		setString(&updated.PromoCode, req.PromoCode)
+		setString(&updated.Status, req.Status)
+		setString(&updated.NewsletterStage, req.NewsletterStage)
+		setString(&updated.Locale, req.Locale)
+		if req.Years != nil {
+			updated.Years = *req.Years
+		}
+		if req.IsGift != nil {
+			updated.IsGift = *req.IsGift
+		}
+		if req.AmountPaid != nil {
+			updated.AmountPaid = *req.AmountPaid
+		}
+
+		changes := customerChanges(c, &updated)
+		if len(changes) > 0 {
+			// A new address can be someone else; merging is done on the contacts
+			if normalizeEmail(updated.Email) != normalizeEmail(c.Email) {
+				if updated.ContactID, err = contactIDFor(db, updated.Email, updated.Name); err != nil {
+					writeError(w, http.StatusInternalServerError, err.Error())
+					return
+				}
+			}
+			_, err := db.Exec(`UPDATE customers SET name = ?, email = ?, phone = ?, country = ?, tree_type = ?, years = ?, is_gift = ?,
+				promo_code = ?, amount_paid = ?, status = ?, newsletter_stage = ?, locale = ?, contact_id = ? WHERE id = ?`,
+				updated.Name, updated.Email, updated.Phone, updated.Country, updated.TreeType, updated.Years, updated.IsGift,
+				updated.PromoCode, updated.AmountPaid, updated.Status, updated.NewsletterStage, updated.Locale, updated.ContactID, id)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			logStaffActivity(&updated, staffID, "updated", "Changed "+strings.Join(changes, "; "))
+			log.Printf("✏️ Customer #%d updated by staff #%d: %s", id, staffID, strings.Join(changes, "; "))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "customer": updated, "changes": changes})
+
+	case http.MethodDelete:
+		// Harvests and diary posts for the tree are history the adopter has
+		// been told about, so those adoptions stay
+		var refs int
+		db.QueryRow(`SELECT (SELECT COUNT(*) FROM harvests WHERE customer_id = ?) + (SELECT COUNT(*) FROM harvest_shares WHERE customer_id = ?)
+			+ (SELECT COUNT(*) FROM diary_posts WHERE customer_id = ?)`, id, id, id).Scan(&refs)
+		if refs > 0 {
+			writeError(w, http.StatusConflict, "The adoption has harvests or diary posts and cannot be deleted")
+			return
+		}
+		if _, err := db.Exec("DELETE FROM customers WHERE id = ?", id); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		// The log keeps the customer's id, so the deletion stays in their history
+		logStaffActivity(c, staffID, "deleted", fmt.Sprintf("Deleted %s <%s>, %s, %s", c.Name, c.Email, c.TreeType, c.Status))
+		log.Printf("🗑️ Customer #%d (%s) deleted by staff #%d", id, c.Email, staffID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	}
+}
+
+// customerChanges describes each field that differs, e.g. `status: "interested" → "paid"`
+func customerChanges(old, updated *Customer) []string {
+	fields := []struct {
+		name     string
+		from, to interface{}
+	}{
+		{"name", old.Name, updated.Name},
+		{"email", old.Email, updated.Email},
+		{"phone", old.Phone, updated.Phone},
+		{"country", old.Country, updated.Country},
+		{"treeType", old.TreeType, updated.TreeType},
+		{"years", old.Years, updated.Years},
+		{"isGift", old.IsGift, updated.IsGift},
+		{"promoCode", old.PromoCode, updated.PromoCode},
+		{"amountPaid", old.AmountPaid, updated.AmountPaid},
+		{"status", old.Status, updated.Status},
+		{"newsletterStage", old.NewsletterStage, updated.NewsletterStage},
+		{"locale", old.Locale, updated.Locale},
+	}
+	changes := []string{}
+	for _, f := range fields {
+		if f.from != f.to {
+			changes = append(changes, fmt.Sprintf("%s: %q → %q", f.name, fmt.Sprint(f.from), fmt.Sprint(f.to)))
+		}
+	}
+	return changes
+}
+
+// logStaffActivity is logActivity for a change a staff member made by hand
+func logStaffActivity(c *Customer, staffID int64, action, message string) {
+	var name string
+	db.QueryRow("SELECT name FROM staff WHERE id = ?", staffID).Scan(&name)
+	db.Exec("INSERT INTO activity_log (customer_id, contact_id, action, message, staff_id) VALUES (?, NULLIF(?, 0), ?, ?, ?)",
+		c.ID, c.ContactID, action, name+": "+message, staffID)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiaryPost is a staff update from the orchard, shown to the adopters it
+// concerns: everyone (orchard), one variety, or a single adopted tree
+type DiaryPost struct {
+	ID           int64    `json:"id"`
+	Scope        string   `json:"scope" validate:"required,oneof=orchard|variety|tree"`
+	Variety      string   `json:"variety,omitempty" validate:"max=100"`
+	CustomerID   int64    `json:"customerId,omitempty"` // the adoption for a tree post
+	Stage        string   `json:"stage" validate:"oneof=blossom|fruit_set|harvest|other"`
+	Title        string   `json:"title" validate:"required,max=200"`
+	Body         string   `json:"body" validate:"max=20000"`
+	Photos       []string `json:"photos"`
+	InNewsletter bool     `json:"inNewsletter"`
+	DigestedAt   string   `json:"digestedAt,omitempty"` // when it went out in a monthly email
+	CreatedAt    string   `json:"createdAt"`
+}
+
+const diarySelectSQL = `SELECT id, scope, COALESCE(variety, ''), COALESCE(customer_id, 0), stage, title, body, photos,
+	in_newsletter, COALESCE(digested_at, ''), created_at FROM diary_posts`
+
+func initDiaryTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS diary_posts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		scope TEXT DEFAULT 'orchard',
+		variety TEXT,
+		customer_id INTEGER,
+		stage TEXT DEFAULT 'other',
+		title TEXT,
+		body TEXT,
+		photos TEXT DEFAULT '[]',
+		in_newsletter BOOLEAN DEFAULT 1,
+		digested_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(customer_id) REFERENCES customers(id)
+	);
+	CREATE TABLE IF NOT EXISTS diary_digests (
+		month TEXT PRIMARY KEY,
+		posts INTEGER,
+		recipients INTEGER,
+		sent_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating diary tables: %v", err)
+	}
+}
+
+func scanDiaryPost(row interface{ Scan(...interface{}) error }) (*DiaryPost, error) {
+	var p DiaryPost
+	var photos string
+	if err := row.Scan(&p.ID, &p.Scope, &p.Variety, &p.CustomerID, &p.Stage, &p.Title, &p.Body, &photos,
+		&p.InNewsletter, &p.DigestedAt, &p.CreatedAt); err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(photos), &p.Photos)
+	if p.Photos == nil {
+		p.Photos = []string{}
+	}
+	p.CreatedAt = farmTime(p.CreatedAt)
+	if p.DigestedAt != "" {
+		p.DigestedAt = farmTime(p.DigestedAt)
+	}
+	return &p, nil
+}
+
+func queryDiaryPosts(where string, args ...interface{}) ([]DiaryPost, error) {
+	rows, err := db.Query(diarySelectSQL+" "+where+" ORDER BY created_at DESC, id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []DiaryPost{}
+	for rows.Next() {
+		p, err := scanDiaryPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, *p)
+	}
+	return posts, nil
+}
+
+// diaryPostsFor returns the posts that concern an adopter's trees: orchard
+// news, their varieties, and their own trees
+func diaryPostsFor(email string, extra string, args ...interface{}) ([]DiaryPost, error) {
+	where := `WHERE (scope = 'orchard'
+		OR (scope = 'variety' AND lower(variety) IN (SELECT lower(tree_type) FROM customers WHERE lower(trim(email)) = ? AND status != 'interested'))
+		OR (scope = 'tree' AND customer_id IN (SELECT id FROM customers WHERE lower(trim(email)) = ? AND status != 'interested')))`
+	if extra != "" {
+		where += " AND " + extra
+	}
+	return queryDiaryPosts(where, append([]interface{}{email, email}, args...)...)
+}
+
+// handleDiary manages the tree diary.
+// GET lists posts (?variety= or ?customerId= narrows it), POST takes a
+// multipart form (title, body, stage, scope, variety, customerId,
+// inNewsletter, any number of "photos" to upload and "mediaId"s of images
+// already in the media library) and DELETE ?id= removes a post. Posting and
+// deleting need a staff key, like other uploads.
+func handleDiary(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		where, args := "", []interface{}{}
+		if v := r.URL.Query().Get("variety"); v != "" {
+			where, args = "WHERE scope = 'orchard' OR lower(variety) = lower(?)", append(args, v)
+		} else if id, _ := strconv.ParseInt(r.URL.Query().Get("customerId"), 10, 64); id != 0 {
+			where = `WHERE scope = 'orchard' OR customer_id = ?
+				OR (scope = 'variety' AND lower(variety) = (SELECT lower(tree_type) FROM customers WHERE id = ?))`
+			args = append(args, id, id)
+		}
+		posts, err := queryDiaryPosts(where, args...)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(posts)
+
+	case http.MethodPost:
+		staffID, ok := requireStaff(w, r)
+		if !ok {
+			return
+		}
+		// Room for a post with several full-size photos
+		r.Body = http.MaxBytesReader(w, r.Body, 10*maxMediaBytes())
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			writeError(w, http.StatusBadRequest, "Expected a multipart form: "+err.Error())
+			return
+		}
+		p := DiaryPost{
+			Scope:        r.FormValue("scope"),
+			Variety:      strings.TrimSpace(r.FormValue("variety")),
+			Stage:        r.FormValue("stage"),
+			Title:        strings.TrimSpace(r.FormValue("title")),
+			Body:         strings.TrimSpace(r.FormValue("body")),
+			InNewsletter: r.FormValue("inNewsletter") != "false" && r.FormValue("inNewsletter") != "0",
+			Photos:       []string{},
+		}
+		p.CustomerID, _ = strconv.ParseInt(r.FormValue("customerId"), 10, 64)
+		if p.Stage == "" {
+			p.Stage = "other"
+		}
+		// A form rather than JSON, but the same rules apply
+		if errs := validateStruct(&p); len(errs) > 0 {
+			writeFieldErrors(w, errs)
+			return
+		}
+		switch p.Scope {
+		case "orchard":
+			p.Variety, p.CustomerID = "", 0
+		case "variety":
+			if p.Variety == "" {
+				writeFieldErrors(w, fieldErrors{"variety": "is required for a variety post"})
+				return
+			}
+			p.CustomerID = 0
+		case "tree":
+			if err := db.QueryRow("SELECT tree_type FROM customers WHERE id = ?", p.CustomerID).Scan(&p.Variety); err != nil {
+				writeFieldErrors(w, fieldErrors{"customerId": "must be an adoption"})
+				return
+			}
+		}
+
+		for _, v := range r.MultipartForm.Value["mediaId"] {
+			id, _ := strconv.ParseInt(v, 10, 64)
+			m, err := getMedia(id)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Unknown image: "+v)
+				return
+			}
+			p.Photos = append(p.Photos, m.URL)
+		}
+		uploaded, err := saveMediaFiles(r.MultipartForm.File["photos"], p.Title, staffID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for _, m := range uploaded {
+			p.Photos = append(p.Photos, m.URL)
+		}
+
+		photos, _ := json.Marshal(p.Photos)
+		var variety, customerID interface{}
+		if p.Variety != "" {
+			variety = p.Variety
+		}
+		if p.CustomerID != 0 {
+			customerID = p.CustomerID
+		}
+		now := time.Now()
+		res, err := db.Exec("INSERT INTO diary_posts (scope, variety, customer_id, stage, title, body, photos, in_newsletter, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			p.Scope, variety, customerID, p.Stage, p.Title, p.Body, string(photos), p.InNewsletter, dbTime(now))
+		if err != nil {
+			deleteMediaItems(uploaded)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		p.ID, _ = res.LastInsertId()
+		p.CreatedAt = farmTime(dbTime(now))
+		log.Printf("📔 Diary post #%d (%s): %s, %d photo(s)", p.ID, p.Scope, p.Title, len(p.Photos))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "post": p})
+
+	case http.MethodDelete:
+		staffID, ok := requireStaff(w, r)
+		if !ok {
+			return
+		}
+		id, _ := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+		// The photos stay in the media library
+		res, err := db.Exec("DELETE FROM diary_posts WHERE id = ?", id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, http.StatusNotFound, "Post not found")
+			return
+		}
+		log.Printf("🗑️ Diary post #%d deleted by staff #%d", id, staffID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// diaryDigestDay is the day of the month the diary digest goes out
+func diaryDigestDay() int {
+	if day, err := strconv.Atoi(os.Getenv("DIARY_DIGEST_DAY")); err == nil && day >= 1 && day <= 28 {
+		return day
+	}
+	return 1
+}
+
+// sendDiaryDigest emails each newsletter subscriber the diary posts for
+// their trees that haven't been in a digest yet, then marks those posts as
+// sent. Subscribers still on the welcome series move on to the monthly one.
+func sendDiaryDigest(month string) (posts, recipients int, err error) {
+	rows, err := db.Query(`SELECT lower(trim(email)), MIN(name) FROM customers
+		WHERE newsletter_stage IN ('welcome', 'monthly') AND status != 'interested' GROUP BY lower(trim(email))`)
+	if err != nil {
+		return 0, 0, err
+	}
+	type subscriber struct{ email, name string }
+	var subscribers []subscriber
+	for rows.Next() {
+		var s subscriber
+		if rows.Scan(&s.email, &s.name) == nil {
+			subscribers = append(subscribers, s)
+		}
+	}
+	rows.Close()
+
+	pending, err := queryDiaryPosts("WHERE in_newsletter = 1 AND digested_at IS NULL")
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(pending) == 0 {
+		return 0, 0, nil
+	}
+
+	for _, s := range subscribers {
+		mine, err := diaryPostsFor(s.email, "in_newsletter = 1 AND digested_at IS NULL")
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(mine) == 0 {
+			continue
+		}
+		type digestPost struct {
+			Title, Underline, Body string
+			Photos                 []string
+		}
+		var posts []digestPost
+		for i := len(mine) - 1; i >= 0; i-- {
+			p := mine[i]
+			photos := make([]string, len(p.Photos))
+			for j, photo := range p.Photos {
+				photos[j] = publicURL(photo)
+			}
+			posts = append(posts, digestPost{p.Title, strings.Repeat("-", len([]rune(p.Title))), p.Body, photos})
+		}
+		sendCustomerEmail(s.email, preferredLocale(s.email, ""), "diary_digest", map[string]interface{}{
+			"Name":      s.name,
+			"Posts":     posts,
+			"PortalURL": publicURL("/portal.html"),
+		})
+		db.Exec("UPDATE customers SET newsletter_stage = 'monthly' WHERE lower(trim(email)) = ? AND newsletter_stage = 'welcome'", s.email)
+		recipients++
+	}
+
+	ids := make([]string, len(pending))
+	for i, p := range pending {
+		ids[i] = strconv.FormatInt(p.ID, 10)
+	}
+	db.Exec("UPDATE diary_posts SET digested_at = ? WHERE id IN ("+strings.Join(ids, ",")+")", dbTime(time.Now()))
+	db.Exec("INSERT OR REPLACE INTO diary_digests (month, posts, recipients, sent_at) VALUES (?, ?, ?, ?)", month, len(pending), recipients, dbTime(time.Now()))
+	log.Printf("📬 Diary digest %s: %d post(s) to %d subscriber(s)", month, len(pending), recipients)
+	return len(pending), recipients, nil
+}
+
+// runDiaryDigest sends this month's digest once the digest day has come.
+// Called from the sweeper every minute; the diary_digests row makes it once
+// a month.
+func runDiaryDigest() {
+	now := time.Now().In(farmLocation)
+	if now.Day() < diaryDigestDay() {
+		return
+	}
+	month := now.Format("2006-01")
+	var sent sql.NullString
+	db.QueryRow("SELECT sent_at FROM diary_digests WHERE month = ?", month).Scan(&sent)
+	if sent.Valid {
+		return
+	}
+	if _, _, err := sendDiaryDigest(month); err != nil {
+		log.Printf("Error sending diary digest: %v", err)
+		return
+	}
+	// Record the month even when nothing was pending so it isn't retried
+	db.Exec("INSERT OR IGNORE INTO diary_digests (month, posts, recipients) VALUES (?, 0, 0)", month)
+}
+
+// handleDiaryDigest sends the pending diary posts right away (POST) instead
+// of waiting for the digest day. It emails every subscriber, so it needs a
+// staff key.
+func handleDiaryDigest(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	posts, recipients, err := sendDiaryDigest(time.Now().In(farmLocation).Format("2006-01"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("📬 Diary digest sent early by staff #%d", staffID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "posts": posts, "recipients": recipients})
+}
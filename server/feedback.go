@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Feedback is one answer to the experience or farm shop survey
+type Feedback struct {
+	ID             int64  `json:"id"`
+	SurveyType     string `json:"surveyType" validate:"required,oneof=experience|farmshop"`
+	Rating         int    `json:"rating" validate:"min=1,max=5"`
+	Experience     string `json:"experience" validate:"max=2000"`
+	Highlight      string `json:"highlight" validate:"max=2000"`
+	Improvement    string `json:"improvement" validate:"max=2000"`
+	WouldRecommend bool   `json:"wouldRecommend"`
+	Email          string `json:"email" validate:"email"` // optional, links the answer to a customer
+	CreatedAt      string `json:"createdAt"`
+}
+
+func initFeedbackTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS feedback (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		survey_type TEXT,
+		rating INTEGER,
+		experience TEXT DEFAULT '',
+		highlight TEXT DEFAULT '',
+		improvement TEXT DEFAULT '',
+		would_recommend BOOLEAN DEFAULT 0,
+		email TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating feedback tables: %v", err)
+	}
+}
+
+// handleSubmitFeedback stores a survey answer from the /feedback pages
+func handleSubmitFeedback(w http.ResponseWriter, r *http.Request) {
+	var f Feedback
+	if !decodeJSON(w, r, &f) {
+		return
+	}
+
+	contactID, err := contactIDFor(db, f.Email, "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	res, err := db.Exec(`INSERT INTO feedback (survey_type, rating, experience, highlight, improvement, would_recommend, email, contact_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, 0))`,
+		f.SurveyType, f.Rating, strings.TrimSpace(f.Experience), strings.TrimSpace(f.Highlight),
+		strings.TrimSpace(f.Improvement), f.WouldRecommend, strings.TrimSpace(f.Email), contactID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	f.ID, _ = res.LastInsertId()
+	log.Printf("💬 Feedback #%d: %s survey, %d/5", f.ID, f.SurveyType, f.Rating)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": f.ID})
+}
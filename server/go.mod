@@ -5,4 +5,6 @@ go 1.25.5
 require (
 	github.com/joho/godotenv v1.5.1
 	github.com/mattn/go-sqlite3 v1.14.33
+	golang.org/x/image v0.25.0
+	golang.org/x/net v0.47.0
 )
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Harvest is a yield recorded for one season, either for a single adopted
+// tree (CustomerID set) or for every tree of a variety
+type Harvest struct {
+	ID          int64   `json:"id"`
+	Season      int     `json:"season" validate:"min=0,max=2100"`
+	Variety     string  `json:"variety" validate:"max=100"`
+	CustomerID  int64   `json:"customerId,omitempty" validate:"min=0"` // the adoption whose tree was picked
+	Kg          float64 `json:"kg" validate:"required,min=0"`
+	TreeCount   int     `json:"treeCount,omitempty" validate:"min=0"` // trees the variety yield came from
+	HarvestedOn string  `json:"harvestedOn"`
+	Notes       string  `json:"notes" validate:"max=2000"`
+	CreatedAt   string  `json:"createdAt"`
+}
+
+// HarvestShare is what one adopter gets from a season
+type HarvestShare struct {
+	ID           int64    `json:"id,omitempty"` // set once the adopter has been notified
+	CustomerID   int64    `json:"customerId"`
+	CustomerName string   `json:"customerName"`
+	Email        string   `json:"email"`
+	Variety      string   `json:"variety"`
+	Kg           float64  `json:"kg"`
+	Source       string   `json:"source"` // tree or variety
+	Status       string   `json:"status"` // allocated, notified, pickup, shipping, collected, shipped
+	Delivery     string   `json:"delivery,omitempty"`
+	Address      *Address `json:"address,omitempty"`
+	NotifiedAt   string   `json:"notifiedAt,omitempty"`
+}
+
+// VarietyHarvest sums up a variety's season for the report
+type VarietyHarvest struct {
+	Variety   string  `json:"variety"`
+	Kg        float64 `json:"kg"`
+	Trees     int     `json:"trees"`
+	KgPerTree float64 `json:"kgPerTree"`
+	Adopters  int     `json:"adopters"`
+	SharedKg  float64 `json:"sharedKg"`
+}
+
+// activeAdoptionSQL matches adoptions that were paid for and cover the season
+const activeAdoptionSQL = `status IN ('paid', 'email_sent', 'subscribed')
+	AND CAST(strftime('%Y', created_at) AS INTEGER) <= ?
+	AND CAST(strftime('%Y', created_at) AS INTEGER) + COALESCE(years, 1) > ?`
+
+func initHarvestTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS harvests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		season INTEGER,
+		variety TEXT,
+		customer_id INTEGER,
+		kg REAL,
+		tree_count INTEGER DEFAULT 0,
+		harvested_on DATE,
+		notes TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(customer_id) REFERENCES customers(id)
+	);
+	CREATE TABLE IF NOT EXISTS harvest_shares (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		customer_id INTEGER,
+		season INTEGER,
+		variety TEXT,
+		kg REAL,
+		status TEXT DEFAULT 'notified',
+		delivery TEXT,
+		ship_street TEXT,
+		ship_postal_code TEXT,
+		ship_city TEXT,
+		ship_country TEXT,
+		access_token TEXT UNIQUE,
+		notified_at DATETIME,
+		chosen_at DATETIME,
+		UNIQUE(customer_id, season),
+		FOREIGN KEY(customer_id) REFERENCES customers(id)
+	);`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating harvest tables: %v", err)
+	}
+}
+
+// listHarvests returns a season's yield entries
+func listHarvests(season int) ([]Harvest, error) {
+	rows, err := db.Query(`SELECT id, season, variety, COALESCE(customer_id, 0), kg, COALESCE(tree_count, 0),
+		COALESCE(harvested_on, ''), COALESCE(notes, ''), created_at
+		FROM harvests WHERE season = ? ORDER BY harvested_on ASC, id ASC`, season)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	harvests := []Harvest{}
+	for rows.Next() {
+		var h Harvest
+		if err := rows.Scan(&h.ID, &h.Season, &h.Variety, &h.CustomerID, &h.Kg, &h.TreeCount, &h.HarvestedOn, &h.Notes, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		harvests = append(harvests, h)
+	}
+	return harvests, nil
+}
+
+// computeHarvestShares works out every active adopter's apples for a season.
+// A yield recorded for an adopter's own tree is theirs; otherwise they get
+// the average per tree of their variety's yield. Variety yields are spread
+// over treeCount trees, or over the adopted trees without their own yield
+// when no count was given.
+func computeHarvestShares(season int) ([]HarvestShare, []VarietyHarvest, error) {
+	harvests, err := listHarvests(season)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := db.Query(`SELECT id, name, email, tree_type FROM customers WHERE `+activeAdoptionSQL+` ORDER BY tree_type, name`, season, season)
+	if err != nil {
+		return nil, nil, err
+	}
+	var shares []HarvestShare
+	for rows.Next() {
+		var s HarvestShare
+		if err := rows.Scan(&s.CustomerID, &s.CustomerName, &s.Email, &s.Variety); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		shares = append(shares, s)
+	}
+	rows.Close()
+
+	byVariety, order := allocateHarvest(harvests, shares)
+
+	// Shares that were already sent keep the amount the adopter was told
+	sent, err := db.Query(`SELECT id, customer_id, kg, status, COALESCE(delivery, ''), COALESCE(ship_street, ''), COALESCE(ship_postal_code, ''),
+		COALESCE(ship_city, ''), COALESCE(ship_country, ''), notified_at FROM harvest_shares WHERE season = ?`, season)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer sent.Close()
+	stored := map[int64]HarvestShare{}
+	for sent.Next() {
+		var s HarvestShare
+		var a Address
+		if err := sent.Scan(&s.ID, &s.CustomerID, &s.Kg, &s.Status, &s.Delivery, &a.Street, &a.PostalCode, &a.City, &a.Country, &s.NotifiedAt); err != nil {
+			return nil, nil, err
+		}
+		if s.Delivery == "shipping" {
+			s.Address = &a
+		}
+		stored[s.CustomerID] = s
+	}
+	for i := range shares {
+		if st, ok := stored[shares[i].CustomerID]; ok {
+			shares[i].ID, shares[i].Kg, shares[i].Status, shares[i].Delivery, shares[i].Address = st.ID, st.Kg, st.Status, st.Delivery, st.Address
+			shares[i].NotifiedAt = farmTime(st.NotifiedAt)
+		}
+	}
+
+	varieties := []VarietyHarvest{}
+	for _, key := range order {
+		varieties = append(varieties, *byVariety[key])
+	}
+	if shares == nil {
+		shares = []HarvestShare{}
+	}
+	return shares, varieties, nil
+}
+
+// allocateHarvest fills in each share's kg from the yield entries and sums
+// up the varieties, keyed by lowercased name in the order they were first
+// harvested
+func allocateHarvest(harvests []Harvest, shares []HarvestShare) (map[string]*VarietyHarvest, []string) {
+	treeKg := map[int64]float64{}
+	for _, h := range harvests {
+		if h.CustomerID != 0 {
+			treeKg[h.CustomerID] += h.Kg
+		}
+	}
+	// Adopted trees that weren't weighed on their own share the variety yield
+	adopters := map[string]int{}
+	for _, s := range shares {
+		if _, ok := treeKg[s.CustomerID]; !ok {
+			adopters[strings.ToLower(s.Variety)]++
+		}
+	}
+
+	poolKg := map[string]float64{} // variety-level yield, tree yields don't count towards the average
+	byVariety := map[string]*VarietyHarvest{}
+	countedAdopters := map[string]bool{}
+	var order []string
+	for _, h := range harvests {
+		key := strings.ToLower(h.Variety)
+		v, ok := byVariety[key]
+		if !ok {
+			v = &VarietyHarvest{Variety: h.Variety}
+			byVariety[key] = v
+			order = append(order, key)
+		}
+		v.Kg = roundCents(v.Kg + h.Kg)
+		if h.CustomerID != 0 {
+			continue
+		}
+		poolKg[key] += h.Kg
+		if h.TreeCount > 0 {
+			v.Trees += h.TreeCount
+		} else if !countedAdopters[key] {
+			// Every picking day without a count is from the same adopted trees
+			countedAdopters[key] = true
+			v.Trees += adopters[key]
+		}
+	}
+	for key, v := range byVariety {
+		if v.Trees > 0 {
+			v.KgPerTree = roundCents(poolKg[key] / float64(v.Trees))
+		}
+	}
+
+	for i := range shares {
+		s := &shares[i]
+		s.Status = "allocated"
+		if kg, ok := treeKg[s.CustomerID]; ok {
+			s.Kg, s.Source = roundCents(kg), "tree"
+		} else if v, ok := byVariety[strings.ToLower(s.Variety)]; ok {
+			s.Kg, s.Source = v.KgPerTree, "variety"
+		}
+		if v, ok := byVariety[strings.ToLower(s.Variety)]; ok {
+			v.Adopters++
+			v.SharedKg = roundCents(v.SharedKg + s.Kg)
+		}
+	}
+	return byVariety, order
+}
+
+// harvestSeason reads ?season=, defaulting to this year on the farm
+func harvestSeason(r *http.Request) int {
+	if season, err := strconv.Atoi(r.URL.Query().Get("season")); err == nil && season > 2000 {
+		return season
+	}
+	return time.Now().In(farmLocation).Year()
+}
+
+// handleHarvests is the harvest report for /admin/trees.
+// GET ?season= returns the yield entries, a summary per variety and every
+// adopter's share; POST records a yield; DELETE ?id= removes a mistaken one.
+func handleHarvests(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		season := harvestSeason(r)
+		harvests, err := listHarvests(season)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		shares, varieties, err := computeHarvestShares(season)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"season":    season,
+			"harvests":  harvests,
+			"varieties": varieties,
+			"shares":    shares,
+		})
+
+	case http.MethodPost:
+		var h Harvest
+		if !decodeJSON(w, r, &h) {
+			return
+		}
+		h.Variety = strings.TrimSpace(h.Variety)
+		if h.CustomerID != 0 {
+			// A single tree's yield belongs to that adoption's variety
+			if err := db.QueryRow("SELECT tree_type FROM customers WHERE id = ?", h.CustomerID).Scan(&h.Variety); err != nil {
+				writeError(w, http.StatusBadRequest, "Adoption not found")
+				return
+			}
+			h.TreeCount = 0
+		}
+		if h.Season < 2000 {
+			h.Season = time.Now().In(farmLocation).Year()
+		}
+		if h.Variety == "" {
+			writeFieldErrors(w, fieldErrors{"variety": "is required without a customerId"})
+			return
+		}
+		if h.HarvestedOn == "" {
+			h.HarvestedOn = time.Now().In(farmLocation).Format("2006-01-02")
+		} else if _, err := time.Parse("2006-01-02", h.HarvestedOn); err != nil {
+			writeFieldErrors(w, fieldErrors{"harvestedOn": "must be a date like 2025-09-30"})
+			return
+		}
+
+		var customerID interface{}
+		if h.CustomerID != 0 {
+			customerID = h.CustomerID
+		}
+		res, err := db.Exec("INSERT INTO harvests (season, variety, customer_id, kg, tree_count, harvested_on, notes) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			h.Season, h.Variety, customerID, h.Kg, h.TreeCount, h.HarvestedOn, h.Notes)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		h.ID, _ = res.LastInsertId()
+		log.Printf("🍏 Harvest %d: %.1f kg %s", h.Season, h.Kg, h.Variety)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "harvest": h})
+
+	case http.MethodDelete:
+		id, _ := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+		res, err := db.Exec("DELETE FROM harvests WHERE id = ?", id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, http.StatusNotFound, "Harvest not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleHarvestNotify sends "your apples are ready" to adopters with a share
+// this season who haven't been told yet. POST {"season", "variety"}; leaving
+// out the variety notifies every variety that has been harvested.
+func handleHarvestNotify(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Season  int    `json:"season" validate:"min=0,max=2100"`
+		Variety string `json:"variety" validate:"max=100"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Season < 2000 {
+		req.Season = time.Now().In(farmLocation).Year()
+	}
+
+	shares, _, err := computeHarvestShares(req.Season)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	notified := 0
+	for _, s := range shares {
+		if s.Status != "allocated" || s.Kg <= 0 {
+			continue
+		}
+		if req.Variety != "" && !strings.EqualFold(req.Variety, s.Variety) {
+			continue
+		}
+		token := newToken()
+		if _, err := db.Exec(`INSERT INTO harvest_shares (customer_id, season, variety, kg, status, access_token, notified_at)
+			VALUES (?, ?, ?, ?, 'notified', ?, ?)`, s.CustomerID, req.Season, s.Variety, s.Kg, token, dbTime(time.Now())); err != nil {
+			log.Printf("Error storing harvest share for customer %d: %v", s.CustomerID, err)
+			continue
+		}
+		sendCustomerEmail(s.Email, preferredLocale(s.Email, ""), "harvest_ready", map[string]interface{}{
+			"Name":    s.CustomerName,
+			"Variety": s.Variety,
+			"Kg":      s.Kg,
+			"URL":     publicURL("/harvest.html?token=" + token),
+		})
+		logActivity(s.CustomerID, "harvest", fmt.Sprintf("Harvest %d: %.1f kg %s ready, %s notified", req.Season, s.Kg, s.Variety, s.CustomerName))
+		notified++
+	}
+	log.Printf("🍎 Harvest %d: notified %d adopter(s)", req.Season, notified)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "notified": notified})
+}
+
+// handleHarvestShare lets an adopter see their share (GET ?token=) and choose
+// pickup or shipping (POST {"token", "delivery", "address"}). Staff mark the
+// apples collected or shipped with PUT ?id= {"status"}.
+func handleHarvestShare(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	var req struct {
+		Token    string  `json:"token" validate:"max=64"`
+		Delivery string  `json:"delivery" validate:"oneof=pickup|shipping"`
+		Address  Address `json:"address"`
+		Status   string  `json:"status" validate:"oneof=collected|shipped"`
+	}
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Token != "" {
+			token = req.Token
+		}
+	}
+	where, arg := "h.access_token = ?", interface{}(token)
+	if r.Method == http.MethodPut {
+		id, _ := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+		where, arg = "h.id = ?", id
+	} else if token == "" {
+		writeError(w, http.StatusUnauthorized, "Missing token")
+		return
+	}
+
+	var id, customerID int64
+	var season int
+	var kg float64
+	var variety, status, name string
+	var delivery sql.NullString
+	err := db.QueryRow(`SELECT h.id, h.customer_id, h.season, h.variety, h.kg, h.status, h.delivery, c.name
+		FROM harvest_shares h JOIN customers c ON h.customer_id = c.id WHERE `+where, arg).
+		Scan(&id, &customerID, &season, &variety, &kg, &status, &delivery, &name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Harvest share not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"customerName": name,
+			"season":       season,
+			"variety":      variety,
+			"kg":           kg,
+			"status":       status,
+			"delivery":     delivery.String,
+		})
+
+	case http.MethodPost:
+		if status != "notified" && status != "pickup" && status != "shipping" {
+			writeError(w, http.StatusConflict, "Your apples are already on their way")
+			return
+		}
+		switch req.Delivery {
+		case "pickup":
+			req.Address = Address{}
+		case "shipping":
+			if errs := requireAddress(req.Address); len(errs) > 0 {
+				writeFieldErrors(w, errs)
+				return
+			}
+			if req.Address.Country == "" {
+				req.Address.Country = "FI"
+			}
+		default:
+			writeError(w, http.StatusBadRequest, "delivery must be pickup or shipping")
+			return
+		}
+		if _, err := db.Exec(`UPDATE harvest_shares SET status = ?, delivery = ?, ship_street = ?, ship_postal_code = ?, ship_city = ?, ship_country = ?, chosen_at = ?
+			WHERE id = ?`, req.Delivery, req.Delivery, req.Address.Street, req.Address.PostalCode, req.Address.City, req.Address.Country, dbTime(time.Now()), id); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		logActivity(customerID, "harvest", fmt.Sprintf("%s chose %s for their %d apples", name, req.Delivery, season))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	case http.MethodPut:
+		allowed := map[string]string{"collected": "pickup", "shipped": "shipping"}
+		if from, ok := allowed[req.Status]; !ok || from != status {
+			writeError(w, http.StatusConflict, fmt.Sprintf("Share is %s, cannot mark it %s", status, req.Status))
+			return
+		}
+		if _, err := db.Exec("UPDATE harvest_shares SET status = ? WHERE id = ?", req.Status, id); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		logActivity(customerID, "harvest", fmt.Sprintf("%d apples for %s marked %s", season, name, req.Status))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
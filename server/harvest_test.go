@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestAllocateHarvestSeveralPickings(t *testing.T) {
+	harvests := []Harvest{
+		{Variety: "Amorosa", Kg: 100, HarvestedOn: "2026-09-10"},
+		{Variety: "Amorosa", Kg: 100, HarvestedOn: "2026-09-24"},
+	}
+	shares := []HarvestShare{
+		{CustomerID: 1, Variety: "Amorosa"},
+		{CustomerID: 2, Variety: "Amorosa"},
+		{CustomerID: 3, Variety: "amorosa"},
+		{CustomerID: 4, Variety: "Amorosa"},
+	}
+
+	byVariety, order := allocateHarvest(harvests, shares)
+
+	if len(order) != 1 {
+		t.Fatalf("varieties = %v, want one", order)
+	}
+	v := byVariety["amorosa"]
+	if v.Trees != 4 || v.Kg != 200 || v.KgPerTree != 50 || v.SharedKg != 200 {
+		t.Errorf("variety = %+v, want 4 trees, 200 kg, 50 kg per tree, 200 kg shared", *v)
+	}
+	for _, s := range shares {
+		if s.Kg != 50 || s.Source != "variety" {
+			t.Errorf("share for #%d = %v kg from %s, want 50 kg from variety", s.CustomerID, s.Kg, s.Source)
+		}
+	}
+}
+
+func TestAllocateHarvestTreeAndCountedPickings(t *testing.T) {
+	harvests := []Harvest{
+		{Variety: "Amorosa", Kg: 30, CustomerID: 1},
+		{Variety: "Amorosa", Kg: 60, TreeCount: 3},
+		{Variety: "Amorosa", Kg: 30, TreeCount: 3},
+	}
+	shares := []HarvestShare{
+		{CustomerID: 1, Variety: "Amorosa"},
+		{CustomerID: 2, Variety: "Amorosa"},
+	}
+
+	byVariety, _ := allocateHarvest(harvests, shares)
+
+	if shares[0].Kg != 30 || shares[0].Source != "tree" {
+		t.Errorf("own tree share = %v kg from %s, want 30 kg from tree", shares[0].Kg, shares[0].Source)
+	}
+	// Counted entries add up their trees: 90 kg over 6 trees
+	if v := byVariety["amorosa"]; v.Trees != 6 || v.KgPerTree != 15 {
+		t.Errorf("variety = %+v, want 6 trees at 15 kg", *v)
+	}
+	if shares[1].Kg != 15 {
+		t.Errorf("variety share = %v kg, want 15", shares[1].Kg)
+	}
+}
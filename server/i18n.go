@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The site is in Swedish, Finnish and English, the same locales activity and
+// product translations use. UI text lives in locales/<locale>.json and
+// customer emails in emails/<locale>.txt, both loaded at startup.
+
+const localeCookie = "ofvergards_lang"
+
+type localeKey struct{}
+
+var (
+	messageCatalogs = map[string]map[string]string{}
+	emailTemplates  = map[string]*template.Template{}
+)
+
+// defaultLocale is DEFAULT_LOCALE, or Swedish
+func defaultLocale() string {
+	if l := normalizeLocale(os.Getenv("DEFAULT_LOCALE")); l != "" {
+		return l
+	}
+	return "sv"
+}
+
+// normalizeLocale maps "fi", "FI" or "fi-FI" to a supported locale, or ""
+func normalizeLocale(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if i := strings.IndexAny(s, "-_"); i != -1 {
+		s = s[:i]
+	}
+	for _, l := range activityLocales {
+		if s == l {
+			return l
+		}
+	}
+	return ""
+}
+
+// loadLocales reads the message catalogs and email templates for every locale
+func loadLocales() error {
+	for _, locale := range activityLocales {
+		data, err := os.ReadFile(filepath.Join("locales", locale+".json"))
+		if err != nil {
+			return err
+		}
+		catalog := map[string]string{}
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return fmt.Errorf("locales/%s.json: %v", locale, err)
+		}
+		messageCatalogs[locale] = catalog
+
+		t, err := template.ParseFiles(filepath.Join("emails", locale+".txt"))
+		if err != nil {
+			return err
+		}
+		emailTemplates[locale] = t
+	}
+	return nil
+}
+
+// T looks up a UI message, falling back to English, then Swedish, then the
+// key itself. With args the message is a fmt format.
+func T(locale, key string, args ...interface{}) string {
+	msg, ok := messageCatalogs[locale][key]
+	for _, fallback := range []string{"en", "sv"} {
+		if !ok {
+			msg, ok = messageCatalogs[fallback][key]
+		}
+	}
+	if !ok {
+		msg = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// requestLocale picks the visitor's language: a /sv, /fi or /en path prefix
+// (already stripped by withLocale), the language cookie, Accept-Language,
+// and finally the default
+func requestLocale(r *http.Request) string {
+	if l, ok := r.Context().Value(localeKey{}).(string); ok {
+		return l
+	}
+	if c, err := r.Cookie(localeCookie); err == nil {
+		if l := normalizeLocale(c.Value); l != "" {
+			return l
+		}
+	}
+	if l := acceptLanguage(r.Header.Get("Accept-Language")); l != "" {
+		return l
+	}
+	return defaultLocale()
+}
+
+// acceptLanguage returns the supported locale the browser ranks highest
+func acceptLanguage(header string) string {
+	type choice struct {
+		locale string
+		q      float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		locale := normalizeLocale(fields[0])
+		if locale == "" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(f), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			choices = append(choices, choice{locale, q})
+		}
+	}
+	// Stable, so equal weights keep the browser's order
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	if len(choices) == 0 {
+		return ""
+	}
+	return choices[0].locale
+}
+
+// withLocale strips a /sv, /fi or /en prefix from the path, remembers the
+// choice in a cookie and makes the negotiated locale available to
+// requestLocale
+func withLocale(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := ""
+		for _, l := range activityLocales {
+			prefix := "/" + l
+			if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+				locale = l
+				r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+				if r.URL.Path == "" {
+					r.URL.Path = "/"
+				}
+				r.URL.RawPath = ""
+				http.SetCookie(w, &http.Cookie{
+					Name:     localeCookie,
+					Value:    l,
+					Path:     "/",
+					Expires:  time.Now().AddDate(1, 0, 0),
+					SameSite: http.SameSiteLaxMode,
+				})
+				break
+			}
+		}
+		if locale == "" {
+			locale = requestLocale(r)
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), localeKey{}, locale)))
+	})
+}
+
+// renderEmail executes the "<name>.subject" and "<name>" templates from
+// emails/<locale>.txt, falling back to the default locale and then Swedish
+// when the locale has no such email
+func renderEmail(locale, name string, data interface{}) (string, string) {
+	candidates := []string{locale, defaultLocale(), "sv"}
+	for _, l := range candidates {
+		t := emailTemplates[l]
+		if t == nil || t.Lookup(name) == nil {
+			continue
+		}
+		var subject, body bytes.Buffer
+		if err := t.ExecuteTemplate(&subject, name+".subject", data); err != nil {
+			log.Printf("Error rendering %s/%s email subject: %v", l, name, err)
+		}
+		if err := t.ExecuteTemplate(&body, name, data); err != nil {
+			log.Printf("Error rendering %s/%s email: %v", l, name, err)
+		}
+		return strings.TrimSpace(subject.String()), strings.TrimLeft(body.String(), "\n")
+	}
+	log.Printf("Error rendering %s email: no template", name)
+	return name, ""
+}
+
+// sendCustomerEmail renders one of the emails/ templates and sends it. The
+// locale comes from preferredLocale, which callers also use to translate
+// names that go into data.
+func sendCustomerEmail(to, locale, name string, data interface{}) {
+	subject, body := renderEmail(locale, name, data)
+	sendEmail(to, subject, body)
+}
+
+// preferredLocale is the language a customer gets email in: the one an
+// adopter chose in the portal (stored on their adoptions), otherwise the
+// locale stored with the booking, order or request the email is about
+func preferredLocale(email, recordLocale string) string {
+	var stored string
+	db.QueryRow(`SELECT locale FROM customers WHERE lower(trim(email)) = lower(trim(?)) AND COALESCE(locale, '') != ''
+		ORDER BY created_at DESC LIMIT 1`, email).Scan(&stored)
+	if l := normalizeLocale(stored); l != "" {
+		return l
+	}
+	if l := normalizeLocale(recordLocale); l != "" {
+		return l
+	}
+	return defaultLocale()
+}
+
+// NameIn returns the activity's name in a locale, or its default name
+func (a *Activity) NameIn(locale string) string {
+	if name := a.Names[locale]; name != "" {
+		return name
+	}
+	return a.Name
+}
+
+// NameIn returns the product's name in a locale, or its Swedish name
+func (p *Product) NameIn(locale string) string {
+	if name := p.Names[locale]; name != "" {
+		return name
+	}
+	return p.Name
+}
+
+// DescriptionIn returns the product's description in a locale, falling back
+// to the Swedish one
+func (p *Product) DescriptionIn(locale string) string {
+	if desc := p.Descriptions[locale]; desc != "" {
+		return desc
+	}
+	return p.Descriptions["sv"]
+}
+
+// activityNameIn is activityName in a locale
+func activityNameIn(slug, locale string) string {
+	if a, err := getActivityBySlug(db, slug); err == nil {
+		return a.NameIn(locale)
+	}
+	return slug
+}
+
+func initLocaleTables() {
+	// Migrations: the language each customer record was made in, for emails
+	for _, table := range []string{"customers", "bookings", "orders", "waitlist", "inquiries"} {
+		db.Exec("ALTER TABLE " + table + " ADD COLUMN locale TEXT DEFAULT ''")
+	}
+}
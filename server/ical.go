@@ -0,0 +1,335 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Staff is a farm employee with a private calendar feed
+type Staff struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name" validate:"required,max=200"`
+	Email     string `json:"email" validate:"email"`
+	FeedToken string `json:"feedToken,omitempty"` // only in the response that creates the staff member
+	FeedURL   string `json:"feedUrl,omitempty"`
+	APIKey    string `json:"apiKey,omitempty"` // likewise, and only its hash is stored
+	CreatedAt string `json:"createdAt"`
+}
+
+func initCalendarTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS staff (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT,
+		email TEXT,
+		feed_token TEXT UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating calendar tables: %v", err)
+	}
+
+	// Migration: staff API key for changes, separate from the feed token (see auth.go)
+	db.Exec("ALTER TABLE staff ADD COLUMN api_key_hash TEXT")
+
+	// Migration: per-booking token used for the visitor's .ics download
+	db.Exec("ALTER TABLE bookings ADD COLUMN access_token TEXT")
+
+	// Backfill tokens for bookings made before the column existed
+	rows, err := db.Query("SELECT id FROM bookings WHERE access_token IS NULL OR access_token = ''")
+	if err != nil {
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		db.Exec("UPDATE bookings SET access_token = ? WHERE id = ?", newToken(), id)
+	}
+}
+
+// newToken returns a random hex token for links that act as credentials
+func newToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error generating token: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// icsEscape escapes TEXT values per RFC 5545 section 3.3.11
+func icsEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, ";", `\;`)
+	s = strings.ReplaceAll(s, ",", `\,`)
+	s = strings.ReplaceAll(s, "\r\n", `\n`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return s
+}
+
+// icsTime formats a time as a UTC DATE-TIME value
+func icsTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// icsCalendar accumulates content lines and folds them at 75 octets
+type icsCalendar struct {
+	b strings.Builder
+}
+
+func newICSCalendar(name string) *icsCalendar {
+	c := &icsCalendar{}
+	c.line("BEGIN:VCALENDAR")
+	c.line("VERSION:2.0")
+	c.line("PRODID:-//Ofvergards//Visits//SV")
+	c.line("CALSCALE:GREGORIAN")
+	c.line("METHOD:PUBLISH")
+	c.line("X-WR-CALNAME:" + icsEscape(name))
+	return c
+}
+
+func (c *icsCalendar) line(s string) {
+	for len(s) > 75 {
+		cut := 75
+		// Don't split a multi-byte UTF-8 sequence
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		c.b.WriteString(s[:cut] + "\r\n")
+		s = " " + s[cut:]
+	}
+	c.b.WriteString(s + "\r\n")
+}
+
+func (c *icsCalendar) event(uid string, start, end time.Time, summary, description string) {
+	c.line("BEGIN:VEVENT")
+	c.line("UID:" + uid)
+	c.line("DTSTAMP:" + icsTime(time.Now()))
+	c.line("DTSTART:" + icsTime(start))
+	c.line("DTEND:" + icsTime(end))
+	c.line("SUMMARY:" + icsEscape(summary))
+	if description != "" {
+		c.line("DESCRIPTION:" + icsEscape(description))
+	}
+	c.line("LOCATION:" + icsEscape("Öfvergårds, Åland"))
+	c.line("END:VEVENT")
+}
+
+func (c *icsCalendar) String() string {
+	return c.b.String() + "END:VCALENDAR\r\n"
+}
+
+func writeICS(w http.ResponseWriter, filename, body string) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(body))
+}
+
+// handleBookingICS serves a single booking as an .ics file.
+// GET /api/bookings/ics?token=<booking access token>
+func handleBookingICS(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "Missing token")
+		return
+	}
+
+	var bookingID int64
+	var quantity int
+	var status, activity, startStr, endStr string
+	err := db.QueryRow(`
+		SELECT b.id, b.quantity, b.status, s.activity, s.start_time, s.end_time
+		FROM bookings b JOIN slots s ON b.slot_id = s.id
+		WHERE b.access_token = ?`, token).Scan(&bookingID, &quantity, &status, &activity, &startStr, &endStr)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Booking not found")
+		return
+	}
+
+	start, err1 := parseSlotTime(startStr)
+	end, err2 := parseSlotTime(endStr)
+	if err1 != nil || err2 != nil {
+		writeError(w, http.StatusInternalServerError, "Invalid slot time")
+		return
+	}
+
+	name := activity
+	if a, err := getActivityBySlug(db, activity); err == nil {
+		name = a.Name
+	}
+
+	cal := newICSCalendar("Öfvergårds")
+	cal.event(fmt.Sprintf("booking-%d@ofvergards.ax", bookingID), start, end,
+		name+" på Öfvergårds",
+		fmt.Sprintf("Bokning #%d, %d personer (%s)", bookingID, quantity, status))
+	writeICS(w, fmt.Sprintf("ofvergards-booking-%d.ics", bookingID), cal.String())
+}
+
+// handleStaffFeed serves every slot with its bookings as a subscribable feed.
+// GET /calendar/staff.ics?token=<staff feed token>
+// Calendar apps can't send auth headers, so the per-staff token is the credential.
+func handleStaffFeed(w http.ResponseWriter, r *http.Request) {
+	var staffName string
+	token := r.URL.Query().Get("token")
+	if token == "" || db.QueryRow("SELECT name FROM staff WHERE feed_token = ?", token).Scan(&staffName) != nil {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Recent history plus everything upcoming keeps the feed small
+	since := dbTime(time.Now().AddDate(0, 0, -30))
+	rows, err := db.Query("SELECT id, activity, start_time, end_time, capacity, booked FROM slots WHERE start_time >= ? AND cancelled_at IS NULL ORDER BY start_time ASC", since)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var slots []Slot
+	for rows.Next() {
+		var s Slot
+		if err := rows.Scan(&s.ID, &s.Activity, &s.StartTime, &s.EndTime, &s.Capacity, &s.Booked); err != nil {
+			continue
+		}
+		slots = append(slots, s)
+	}
+	rows.Close()
+
+	// Guest lists per slot, of the bookings still coming
+	guests := map[int64][]string{}
+	rows, err = db.Query(`
+		SELECT b.slot_id, b.customer_name, b.quantity, b.status
+		FROM bookings b JOIN slots s ON b.slot_id = s.id
+		WHERE s.start_time >= ? AND b.status IN ('pending', 'paid', 'confirmed') ORDER BY b.created_at ASC`, since)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for rows.Next() {
+		var slotID int64
+		var name, status string
+		var qty int
+		if err := rows.Scan(&slotID, &name, &qty, &status); err != nil {
+			continue
+		}
+		guests[slotID] = append(guests[slotID], fmt.Sprintf("%s (%d pers, %s)", name, qty, status))
+	}
+	rows.Close()
+
+	names := map[string]string{}
+	if activities, err := listActivities(true); err == nil {
+		for _, a := range activities {
+			names[a.Slug] = a.Name
+		}
+	}
+
+	cal := newICSCalendar("Öfvergårds besök – " + staffName)
+	for _, s := range slots {
+		start, err1 := parseSlotTime(s.StartTime)
+		end, err2 := parseSlotTime(s.EndTime)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		name := names[s.Activity]
+		if name == "" {
+			name = s.Activity
+		}
+		desc := "Inga bokningar ännu"
+		if len(guests[s.ID]) > 0 {
+			desc = strings.Join(guests[s.ID], "\n")
+		}
+		cal.event(fmt.Sprintf("slot-%d@ofvergards.ax", s.ID), start, end,
+			fmt.Sprintf("%s (%d/%d)", name, s.Booked, s.Capacity), desc)
+	}
+	writeICS(w, "ofvergards-staff.ics", cal.String())
+}
+
+// handleStaff lists staff (GET) or adds a staff member with a new feed token
+// and API key (POST). Both need the admin token. The feed token and API key
+// are credentials, so they are only returned once, when the staff member is
+// created.
+func handleStaff(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		rows, err := db.Query("SELECT id, name, email, created_at FROM staff ORDER BY name ASC")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer rows.Close()
+
+		staff := []Staff{}
+		for rows.Next() {
+			var s Staff
+			if err := rows.Scan(&s.ID, &s.Name, &s.Email, &s.CreatedAt); err != nil {
+				continue
+			}
+			staff = append(staff, s)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(staff)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var s Staff
+		if !decodeJSON(w, r, &s) {
+			return
+		}
+		s.FeedToken = newToken()
+		s.APIKey = newToken()
+		res, err := db.Exec("INSERT INTO staff (name, email, feed_token, api_key_hash) VALUES (?, ?, ?, ?)",
+			s.Name, s.Email, s.FeedToken, hashStaffKey(s.APIKey))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.ID, _ = res.LastInsertId()
+		s.FeedURL = "/calendar/staff.ics?token=" + s.FeedToken
+		log.Printf("👤 Staff member #%d (%s) added", s.ID, s.Name)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "staff": s})
+		return
+	}
+
+	writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// handleStaffKey issues a new API key for a staff member and returns it once:
+// POST /api/staff/{id}/key. Needs the admin token. The old key stops working,
+// so this is also how a leaked key is replaced.
+func handleStaffKey(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+
+	key := newToken()
+	res, err := db.Exec("UPDATE staff SET api_key_hash = ? WHERE id = ?", hashStaffKey(key), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "Staff member not found")
+		return
+	}
+	log.Printf("🔑 New API key issued for staff member #%d", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": id, "apiKey": key})
+}
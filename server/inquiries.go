@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// suggestAlternativeSlots finds up to limit public slots of the inquiry's
+// activity that still have seats, closest to the requested date when it can
+// be parsed and otherwise the soonest ones
+func suggestAlternativeSlots(inq Inquiry, limit int) ([]SlotAvailability, error) {
+	target := time.Now()
+	if t, err := parseFarmTime(inq.ProposedDate); err == nil && t.After(target) {
+		target = t
+	}
+	return alternativeSlots(inq.Activity, target, 1, limit)
+}
+
+// alternativeSlots returns up to limit upcoming public slots of an activity
+// with room for party, ordered by closeness to target
+func alternativeSlots(activity string, target time.Time, party, limit int) ([]SlotAvailability, error) {
+	now := time.Now()
+	rows, err := db.Query(`
+		SELECT id, activity, start_time, end_time, capacity, booked,
+			(SELECT COALESCE(SUM(party_size), 0) FROM waitlist w
+				WHERE w.slot_id = slots.id AND w.status = 'offered' AND w.offer_expires_at > ?)
+		FROM slots
+		WHERE activity = ? AND start_time > ? AND private_token IS NULL AND cancelled_at IS NULL
+		ORDER BY ABS(julianday(start_time) - julianday(?)) ASC`,
+		dbTime(now), activity, dbTime(now), dbTime(target))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alternatives := []SlotAvailability{}
+	for rows.Next() && len(alternatives) < limit {
+		var s Slot
+		var held int
+		if err := rows.Scan(&s.ID, &s.Activity, &s.StartTime, &s.EndTime, &s.Capacity, &s.Booked, &held); err != nil {
+			continue
+		}
+		remaining := s.Capacity - s.Booked - held
+		if remaining < party || remaining <= 0 {
+			continue
+		}
+		s.localize()
+		alternatives = append(alternatives, SlotAvailability{
+			Slot:       s,
+			Remaining:  remaining,
+			NearlyFull: isNearlyFull(remaining, s.Capacity),
+		})
+	}
+	return alternatives, nil
+}
+
+// sendInquiryAccepted emails the requester the link to their private slot
+// and returns that link
+func sendInquiryAccepted(inq Inquiry, activity *Activity, start time.Time, token string) string {
+	url := publicURL("/book-visit.html?private=" + token)
+	locale := preferredLocale(inq.Email, inq.Locale)
+	sendCustomerEmail(inq.Email, locale, "inquiry_accepted", map[string]interface{}{
+		"Name":     inq.Name,
+		"Activity": activity.NameIn(locale),
+		"When":     start.In(farmLocation).Format("2006-01-02 15:04"),
+		"URL":      url,
+	})
+	return url
+}
+
+// sendInquiryDeclined emails the requester a polite no with other times
+func sendInquiryDeclined(inq Inquiry, alternatives []SlotAvailability, note string) {
+	type alternative struct {
+		When      string
+		Remaining int
+	}
+	var alts []alternative
+	for _, a := range alternatives {
+		when := a.StartTime
+		if t, err := time.Parse(time.RFC3339, a.StartTime); err == nil {
+			when = t.In(farmLocation).Format("2006-01-02 15:04")
+		}
+		alts = append(alts, alternative{When: when, Remaining: a.Remaining})
+	}
+
+	locale := preferredLocale(inq.Email, inq.Locale)
+	sendCustomerEmail(inq.Email, locale, "inquiry_declined", map[string]interface{}{
+		"Name":         inq.Name,
+		"Activity":     activityNameIn(inq.Activity, locale),
+		"ProposedDate": inq.ProposedDate,
+		"Note":         note,
+		"Alternatives": alts,
+		"URL":          publicURL("/book-visit.html?activity=" + inq.Activity),
+	})
+}
+
+// handlePrivateSlot resolves the link emailed for an accepted inquiry so the
+// booking page can show the slot. GET /api/slots/private?token=
+func handlePrivateSlot(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "Missing token")
+		return
+	}
+
+	var s Slot
+	var name, email string
+	var cancelled bool
+	err := db.QueryRow(`
+		SELECT s.id, s.activity, s.start_time, s.end_time, s.capacity, s.booked, s.inquiry_id, s.cancelled_at IS NOT NULL, i.name, i.email
+		FROM slots s JOIN inquiries i ON s.inquiry_id = i.id
+		WHERE s.private_token = ?`, token).Scan(&s.ID, &s.Activity, &s.StartTime, &s.EndTime, &s.Capacity, &s.Booked, &s.InquiryID, &cancelled, &name, &email)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Slot not found")
+		return
+	}
+	if start, err := parseSlotTime(s.StartTime); err != nil || !start.After(time.Now()) {
+		writeError(w, http.StatusGone, "This time has already passed")
+		return
+	}
+	if cancelled {
+		writeError(w, http.StatusGone, "This time has been cancelled")
+		return
+	}
+	s.Private = true
+	s.localize()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"slot":  s,
+		"name":  name,
+		"email": email,
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// publicURL turns a site path into an absolute link for emails, using
+// PUBLIC_BASE_URL (e.g. "https://ofvergards.ax")
+func publicURL(path string) string {
+	base := os.Getenv("PUBLIC_BASE_URL")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
+
+func initMailTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS emails (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		to_email TEXT,
+		subject TEXT,
+		body TEXT,
+		sent_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating mail tables: %v", err)
+	}
+}
+
+// sendEmail is the single place mail leaves the system. There is no SMTP
+// provider yet, so it logs the message and records it in the emails table.
+func sendEmail(to, subject, body string) {
+	log.Printf("✉️  MOCK: Email to %s: %s\n%s", to, subject, body)
+	if _, err := db.Exec(`INSERT INTO emails (to_email, subject, body, contact_id)
+		VALUES (?, ?, ?, (SELECT contact_id FROM contact_emails WHERE email = ?))`, to, subject, body, normalizeEmail(to)); err != nil {
+		log.Printf("Error recording email to %s: %v", to, err)
+	}
+}
+
+// notifyAdmin emails the farm office (ADMIN_EMAIL) about something that
+// needs a person, such as a product running low
+func notifyAdmin(subject, body string) {
+	to := os.Getenv("ADMIN_EMAIL")
+	if to == "" {
+		to = "info@ofvergards.ax"
+	}
+	log.Printf("⚠️  %s", subject)
+	sendEmail(to, subject, body)
+}
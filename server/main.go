@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,14 +18,21 @@ import (
 
 // Customer represents an adopter in the system
 type Customer struct {
-	ID              int64  `json:"id"`
-	Name            string `json:"name"`
-	Email           string `json:"email"`
-	Country         string `json:"country"`
-	TreeType        string `json:"treeType"`
-	Status          string `json:"status"`          // interested, paid, email_sent, subscribed
-	NewsletterStage string `json:"newsletterStage"` // none, welcome, monthly
-	CreatedAt       string `json:"createdAt"`
+	ID              int64   `json:"id"`
+	Name            string  `json:"name"`
+	Email           string  `json:"email"`
+	Phone           string  `json:"phone"`
+	Country         string  `json:"country"`
+	TreeType        string  `json:"treeType"`
+	Years           int     `json:"years"`
+	IsGift          bool    `json:"isGift"`
+	PromoCode       string  `json:"promoCode"`
+	AmountPaid      float64 `json:"amountPaid"`
+	Status          string  `json:"status"`          // interested, paid, email_sent, subscribed
+	NewsletterStage string  `json:"newsletterStage"` // none, welcome, monthly
+	Locale          string  `json:"locale"`          // the adopter's language for emails
+	ContactID       int64   `json:"contactId"`       // the person, see contacts.go
+	CreatedAt       string  `json:"createdAt"`
 }
 
 // ActivityLog represents automation events
@@ -45,41 +53,50 @@ type PageData struct {
 // Visit Booking Structs
 type Slot struct {
 	ID        int64  `json:"id"`
-	Activity  string `json:"activity"` // safari, tasting, picnic
+	Activity  string `json:"activity"` // activity slug, see activities table
 	StartTime string `json:"startTime"`
 	EndTime   string `json:"endTime"`
 	Capacity  int    `json:"capacity"`
 	Booked    int    `json:"booked"`
+	SeriesID  int64  `json:"seriesId,omitempty"`  // set when generated from a slot series
+	InquiryID int64  `json:"inquiryId,omitempty"` // set for private slots made for an inquiry
+	Private   bool   `json:"private,omitempty"`   // only bookable through the emailed link
+	Cancelled bool   `json:"cancelled,omitempty"` // called off by the farm, e.g. for bad weather
 }
 
 type Booking struct {
-	ID            int64  `json:"id"`
-	SlotID        int64  `json:"slotId"`
-	CustomerName  string `json:"customerName"`
-	CustomerEmail string `json:"customerEmail"`
-	Quantity      int    `json:"quantity"`
-	Status        string `json:"status"` // pending, paid, confirmed
-	PaymentToken  string `json:"paymentToken"`
-	CreatedAt     string `json:"createdAt"`
+	ID            int64   `json:"id"`
+	SlotID        int64   `json:"slotId" validate:"required"`
+	CustomerName  string  `json:"customerName" validate:"required,max=200"`
+	CustomerEmail string  `json:"customerEmail" validate:"required,email"`
+	Quantity      int     `json:"quantity" validate:"min=0,max=500"`
+	Tickets       Tickets `json:"tickets"`     // quantity per ticket type
+	TotalAmount   float64 `json:"totalAmount"` // computed server-side
+	Status        string  `json:"status"`      // pending, paid, confirmed, slot_cancelled, refunded
+	PaymentToken  string  `json:"paymentToken"`
+	CreatedAt     string  `json:"createdAt"`
+	WaitlistToken string  `json:"waitlistToken,omitempty" validate:"max=64"` // offer link token, lets the visitor use held seats
+	SlotToken     string  `json:"slotToken,omitempty" validate:"max=64"`     // required for private slots
 }
 
 type Inquiry struct {
 	ID           int64  `json:"id"`
-	Name         string `json:"name"`
-	Email        string `json:"email"`
-	Activity     string `json:"activity"`
-	ProposedDate string `json:"proposedDate"`
-	Message      string `json:"message"`
+	Name         string `json:"name" validate:"required,max=200"`
+	Email        string `json:"email" validate:"required,email"`
+	Activity     string `json:"activity" validate:"required,max=100"`
+	ProposedDate string `json:"proposedDate" validate:"max=100"`
+	Message      string `json:"message" validate:"max=5000"`
 	Status       string `json:"status"` // pending, accepted, declined
+	Locale       string `json:"locale"` // language the request was made in
 	CreatedAt    string `json:"createdAt"`
 }
 
 type Newsletter struct {
 	ID             int64  `json:"id"`
-	Subject        string `json:"subject"`
-	Content        string `json:"content"`        // HTML
-	FilterCriteria string `json:"filterCriteria"` // e.g. "all", "tree_type:lobjet", "product:safari"
-	Status         string `json:"status"`         // draft, sent
+	Subject        string `json:"subject" validate:"required,max=200"`
+	Content        string `json:"content" validate:"max=200000"`     // HTML
+	FilterCriteria string `json:"filterCriteria" validate:"max=100"` // e.g. "all", "tree_type:lobjet", "product:safari"
+	Status         string `json:"status"`                            // draft, sent
 	CreatedAt      string `json:"createdAt"`
 	SentAt         string `json:"sentAt"`
 }
@@ -132,6 +149,48 @@ func initVisitTables() {
 
 	// Migration: Add status to inquiries if it doesn't exist
 	db.Exec("ALTER TABLE inquiries ADD COLUMN status TEXT DEFAULT 'pending'")
+
+	// Migration: private slots created from accepted inquiries
+	db.Exec("ALTER TABLE slots ADD COLUMN inquiry_id INTEGER REFERENCES inquiries(id)")
+	db.Exec("ALTER TABLE slots ADD COLUMN private_token TEXT")
+
+	// Migration: slot times used to be stored as naive farm time
+	migrateOnce("slot_times_utc", slotTimesToUTC)
+}
+
+// migrateOnce runs a data migration that must not be repeated, like
+// rewriting values in place, and records it in schema_migrations so later
+// starts skip it. It runs in a transaction and is retried on the next start
+// if it fails.
+func migrateOnce(name string, migrate func(tx *sql.Tx) error) {
+	db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		name TEXT PRIMARY KEY,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting migration %s: %v", name, err)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT OR IGNORE INTO schema_migrations (name) VALUES (?)", name)
+	if err != nil {
+		log.Printf("Error recording migration %s: %v", name, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return
+	}
+	if err := migrate(tx); err != nil {
+		log.Printf("Error in migration %s: %v", name, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing migration %s: %v", name, err)
+		return
+	}
+	log.Printf("🛠️ Migration %s applied", name)
 }
 
 var db *sql.DB
@@ -152,10 +211,17 @@ var templateFuncs = template.FuncMap{
 	"split": func(s, sep string) []string {
 		return strings.Split(s, sep)
 	},
+	// {{t .Locale "nav.home"}} looks up UI text in locales/<locale>.json
+	"t": T,
+	"locales": func() []string {
+		return activityLocales
+	},
 }
 
 func main() {
 	godotenv.Load()
+	loadFarmLocation()
+	loadWeatherProvider()
 
 	var err error
 	db, err = sql.Open("sqlite3", "./database.sqlite")
@@ -166,6 +232,24 @@ func main() {
 	// Initialize Database
 	initDB()
 	initVisitTables()
+	initResourceTables()
+	initSeriesTables()
+	initActivityTables()
+	initCalendarTables()
+	initMailTables()
+	initWaitlistTables()
+	initCheckinTables()
+	initWeatherTables()
+	initShopTables()
+	initHarvestTables()
+	initPortalTables()
+	initMediaTables()
+	initContentTables()
+	initDiaryTables()
+	initLocaleTables()
+	initCustomerTables()
+	initFeedbackTables()
+	initContactTables()
 	defer db.Close()
 
 	// Parse Templates
@@ -174,44 +258,195 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error parsing templates: %v", err)
 	}
+	if err := loadPageTemplates(); err != nil {
+		log.Fatalf("Error parsing page templates: %v", err)
+	}
+	if err := loadLocales(); err != nil {
+		log.Fatalf("Error loading translations: %v", err)
+	}
 
 	initDB()
 	initVisitTables()
+	initResourceTables()
+	initSeriesTables()
+	initActivityTables()
+	initCalendarTables()
+	initMailTables()
+	initWaitlistTables()
+	initCheckinTables()
+	initWeatherTables()
+	initShopTables()
+	initHarvestTables()
+	initPortalTables()
+	initMediaTables()
+	initContentTables()
+	initDiaryTables()
+	initLocaleTables()
+	initCustomerTables()
+	initFeedbackTables()
+	initContactTables()
+
+	// Routes carry their method ("GET /api/stats"), so the mux answers other
+	// methods with 405 and handlers don't check r.Method themselves
+	mux := http.NewServeMux()
 
 	// API Routes
-	http.HandleFunc("/api/adopt", handleAdopt)
-	http.HandleFunc("/api/confirm-payment", handleConfirmPayment)
-	http.HandleFunc("/api/customers", handleGetCustomers)
-	http.HandleFunc("/api/activity", handleGetActivity)
-	http.HandleFunc("/api/stats", handleGetStats)
-	http.HandleFunc("/api/promocodes", handlePromoCodes)
-	http.HandleFunc("/api/promocodes/validate", handleValidatePromo)
+	mux.HandleFunc("POST /api/adopt", handleAdopt)
+	mux.HandleFunc("POST /api/confirm-payment", handleConfirmPayment)
+	mux.HandleFunc("GET /api/customers", handleCustomers)
+	mux.HandleFunc("GET /api/customers/{id}", handleCustomer)
+	mux.HandleFunc("PATCH /api/customers/{id}", handleCustomer)
+	mux.HandleFunc("DELETE /api/customers/{id}", handleCustomer)
+	mux.HandleFunc("GET /api/customers/{id}/timeline", handleCustomerTimeline)
+	mux.HandleFunc("GET /api/contacts/duplicates", handleContactDuplicates)
+	mux.HandleFunc("GET /api/contacts/merges", handleContactMerges)
+	mux.HandleFunc("POST /api/contacts/merges/{id}/undo", handleUndoContactMerge)
+	mux.HandleFunc("GET /api/contacts/{id}", handleContact)
+	mux.HandleFunc("POST /api/contacts/{id}/merge", handleMergeContacts)
+	mux.HandleFunc("GET /api/activity", handleGetActivity)
+	mux.HandleFunc("GET /api/stats", handleGetStats)
+	mux.HandleFunc("GET /api/promocodes", handlePromoCodes)
+	mux.HandleFunc("POST /api/promocodes", handlePromoCodes)
+	mux.HandleFunc("POST /api/promocodes/validate", handleValidatePromo)
 
 	// Visit Booking API
-	http.HandleFunc("/api/slots", handleSlots)
-	http.HandleFunc("/api/book-visit", handleBookVisit)
-	http.HandleFunc("/api/inquiry", handleInquiry)
-	http.HandleFunc("/api/confirm-visit", handleConfirmVisit)
+	mux.HandleFunc("GET /api/activities", handleActivities)
+	mux.HandleFunc("POST /api/activities", handleActivities)
+	mux.HandleFunc("PUT /api/activities", handleActivities)
+	mux.HandleFunc("DELETE /api/activities", handleActivities)
+	mux.HandleFunc("GET /api/activities/{slug}", handleActivities)
+	mux.HandleFunc("PUT /api/activities/{slug}", handleActivities)
+	mux.HandleFunc("DELETE /api/activities/{slug}", handleActivities)
+	mux.HandleFunc("GET /api/slots", handleSlots)
+	mux.HandleFunc("POST /api/slots", handleSlots)
+	mux.HandleFunc("GET /api/slots/{id}", handleSlot)
+	mux.HandleFunc("GET /api/slots/private", handlePrivateSlot)
+	mux.HandleFunc("POST /api/slots/cancel", handleCancelSlots)
+	mux.HandleFunc("GET /api/weather/alerts", handleWeatherAlerts)
+	mux.HandleFunc("GET /api/resources", handleResources)
+	mux.HandleFunc("POST /api/resources", handleResources)
+	mux.HandleFunc("DELETE /api/resources", handleResources)
+	mux.HandleFunc("DELETE /api/resources/{id}", handleResources)
+	mux.HandleFunc("GET /api/slot-series", handleSlotSeries)
+	mux.HandleFunc("PUT /api/slot-series", handleSlotSeries)
+	mux.HandleFunc("DELETE /api/slot-series", handleSlotSeries)
+	mux.HandleFunc("GET /api/slot-series/{id}", handleSlotSeries)
+	mux.HandleFunc("PUT /api/slot-series/{id}", handleSlotSeries)
+	mux.HandleFunc("DELETE /api/slot-series/{id}", handleSlotSeries)
+	mux.HandleFunc("GET /api/availability", handleAvailability)
+	mux.HandleFunc("POST /api/book-visit", handleBookVisit)
+	mux.HandleFunc("POST /api/inquiry", handleInquiry)
+	mux.HandleFunc("POST /api/confirm-visit", handleConfirmVisit)
+	mux.HandleFunc("GET /api/bookings/ics", handleBookingICS)
+	mux.HandleFunc("POST /api/bookings/cancel", handleCancelBooking)
+	mux.HandleFunc("GET /api/bookings/ticket", handleBookingTicket)
+	mux.HandleFunc("GET /api/bookings/rebook", handleRebook)
+	mux.HandleFunc("POST /api/bookings/rebook", handleRebook)
+	mux.HandleFunc("GET /api/checkin", handleCheckin)
+	mux.HandleFunc("POST /api/checkin", handleCheckin)
+	mux.HandleFunc("POST /api/checkin/close", handleCheckinClose)
+	mux.HandleFunc("GET /api/checkin/report", handleCheckinReport)
+	mux.HandleFunc("GET /api/waitlist", handleWaitlist)
+	mux.HandleFunc("POST /api/waitlist", handleWaitlist)
+	mux.HandleFunc("DELETE /api/waitlist", handleWaitlist)
+	mux.HandleFunc("DELETE /api/waitlist/{id}", handleWaitlist)
+	mux.HandleFunc("GET /api/waitlist/offer", handleWaitlistOffer)
+
+	// Farm shop
+	mux.HandleFunc("GET /api/products", handleProducts)
+	mux.HandleFunc("POST /api/products", handleProducts)
+	mux.HandleFunc("PUT /api/products", handleProducts)
+	mux.HandleFunc("DELETE /api/products", handleProducts)
+	mux.HandleFunc("GET /api/products/{sku}", handleProducts)
+	mux.HandleFunc("PUT /api/products/{sku}", handleProducts)
+	mux.HandleFunc("DELETE /api/products/{sku}", handleProducts)
+	mux.HandleFunc("GET /api/cart", handleCart)
+	mux.HandleFunc("POST /api/cart", handleCart)
+	mux.HandleFunc("PUT /api/cart", handleCart)
+	mux.HandleFunc("GET /api/orders", handleOrders)
+	mux.HandleFunc("POST /api/orders", handleOrders)
+	mux.HandleFunc("PUT /api/orders", handleOrders)
+	mux.HandleFunc("PUT /api/orders/{id}", handleOrders)
+	mux.HandleFunc("POST /api/confirm-order", handleConfirmOrder)
+	mux.HandleFunc("GET /api/stock", handleStock)
+	mux.HandleFunc("POST /api/stock", handleStock)
+
+	// Harvest shares for adopters
+	mux.HandleFunc("GET /api/harvests", handleHarvests)
+	mux.HandleFunc("POST /api/harvests", handleHarvests)
+	mux.HandleFunc("DELETE /api/harvests", handleHarvests)
+	mux.HandleFunc("DELETE /api/harvests/{id}", handleHarvests)
+	mux.HandleFunc("POST /api/harvests/notify", handleHarvestNotify)
+	mux.HandleFunc("GET /api/harvest-share", handleHarvestShare)
+	mux.HandleFunc("POST /api/harvest-share", handleHarvestShare)
+	mux.HandleFunc("PUT /api/harvest-share", handleHarvestShare)
+	mux.HandleFunc("PUT /api/harvest-share/{id}", handleHarvestShare)
+
+	// Adopter portal (passwordless login by email link)
+	mux.HandleFunc("GET /api/portal", handlePortal)
+	mux.HandleFunc("PUT /api/portal", handlePortal)
+	mux.HandleFunc("POST /api/portal/login-link", handlePortalRequestLink)
+	mux.HandleFunc("POST /api/portal/logout", handlePortalLogout)
+	mux.HandleFunc("GET /portal/login", handlePortalLoginPage)
+	mux.HandleFunc("POST /portal/login", handlePortalLogin)
+
+	// Website content (CMS fields)
+	mux.HandleFunc("GET /api/content", handleContent)
+	mux.HandleFunc("PUT /api/content", handleContent)
+
+	// Media library (image uploads and picker)
+	mux.HandleFunc("GET /api/media", handleMedia)
+	mux.HandleFunc("POST /api/media", handleMedia)
+	mux.HandleFunc("PUT /api/media", handleMedia)
+	mux.HandleFunc("DELETE /api/media", handleMedia)
+	mux.HandleFunc("GET /api/media/{id}", handleMedia)
+	mux.HandleFunc("PUT /api/media/{id}", handleMedia)
+	mux.HandleFunc("DELETE /api/media/{id}", handleMedia)
+
+	// Tree diary
+	mux.HandleFunc("GET /api/diary", handleDiary)
+	mux.HandleFunc("POST /api/diary", handleDiary)
+	mux.HandleFunc("DELETE /api/diary", handleDiary)
+	mux.HandleFunc("DELETE /api/diary/{id}", handleDiary)
+	mux.HandleFunc("POST /api/diary/digest", handleDiaryDigest)
+
+	// Staff, their calendar feeds and API keys
+	mux.HandleFunc("GET /api/staff", handleStaff)
+	mux.HandleFunc("POST /api/staff", handleStaff)
+	mux.HandleFunc("POST /api/staff/{id}/key", handleStaffKey)
+	mux.HandleFunc("GET /calendar/staff.ics", handleStaffFeed)
 
 	// Newsletter API
-	http.HandleFunc("/api/newsletters", handleNewsletters)
+	mux.HandleFunc("GET /api/newsletters", handleNewsletters)
+	mux.HandleFunc("POST /api/newsletters", handleNewsletters)
 
-	// Admin Routes (using templates/old proto logic if needed)
-	http.HandleFunc("/admin/feedback", handleAdminFeedback)
-	http.HandleFunc("/admin/visits", handleAdminVisits)
-	http.HandleFunc("/admin/newsletters", handleAdminNewsletters)
-	http.HandleFunc("/api/inquiries/action", handleInquiryAction)
-	http.HandleFunc("/admin", handleAdminDashboard)   // New main dashboard
-	http.HandleFunc("/admin/trees", handleAdminTrees) // Rent a Tree dashboard
+	// Feedback surveys
+	mux.HandleFunc("POST /api/feedback", handleSubmitFeedback)
 
-	// Serve Static Site (The Mirrored Site)
-	// We check if the file exists in public/, otherwise we check if it's an API or specific page
+	// Admin Routes (using templates/old proto logic if needed)
+	mux.HandleFunc("GET /admin/feedback", handleAdminFeedback)
+	mux.HandleFunc("GET /admin/visits", handleAdminVisits)
+	mux.HandleFunc("GET /admin/checkin", handleAdminCheckin)
+	mux.HandleFunc("GET /admin/newsletters", handleAdminNewsletters)
+	mux.HandleFunc("GET /admin/content", handleContentAdmin)
+	mux.HandleFunc("POST /api/inquiries/action", handleInquiryAction)
+	mux.HandleFunc("GET /admin", handleAdminDashboard)   // New main dashboard
+	mux.HandleFunc("GET /admin/trees", handleAdminTrees) // Rent a Tree dashboard
+	mux.HandleFunc("GET /admin/shop", handleAdminShop)   // Farm shop stock and orders
+
+	// Unknown /api/ paths and methods get a JSON 404 or 405, not the static site
+	mux.HandleFunc("/api/", apiFallback(mux))
+
+	// Serve the template-rendered pages (front page, adopt, products, feedback
+	// surveys) and fall back to the Static Site (The Mirrored Site) for the rest
 
 	fs := http.FileServer(http.Dir("./public"))
-	// Wrap the file server to handle "index.html" for directories automatically (standard behavior)
-	// But we might need custom logic for .html extension hiding if desired.
-	// For now, standard FileServer is fine as the mirrored site uses /path/index.html structure.
-	http.Handle("/", fs)
+	// Standard FileServer handles "index.html" for directories, which is
+	// how the mirrored site is structured (/path/index.html).
+	mux.Handle("/", chain(withPages(fs), withSecurityHeaders))
+
+	// Release unpaid holds and orders, pass freed seats on to the waitlist and record no-shows
+	go runBookingSweeper()
 
 	// Serve Client assets (prototype scripts/css if we need them mixed in)
 	// We'll map /assets/ to the old client folder if needed,
@@ -228,7 +463,9 @@ func main() {
 	fmt.Printf("🍎 Öfvergårds Server starting on port %s...\n", port)
 	fmt.Println("   Open http://localhost:8080")
 	fmt.Println("   Admin dashboard: http://localhost:8080/admin.html (needs to be moved to public)")
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	// Every request passes the same middleware chain; /sv, /fi and /en in
+	// front of any path pick the language
+	log.Fatal(http.ListenAndServe(":"+port, chain(mux, withRecover, withLocale)))
 }
 
 func initDB() {
@@ -278,24 +515,25 @@ func initDB() {
 	db.Exec(createPromoTable)
 }
 
-func handleAdopt(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// treeVarieties are the apple varieties adopters can choose between, the
+// orchard's own and the seasonal names the adopt page offers
+var treeVarieties = []string{
+	"Amorosa", "Discovery", "Rubinola", "Santana", "Zari", "Zonga",
+	"Summer Apple", "Autumn Apple", "Winter Apple",
+}
 
+func handleAdopt(w http.ResponseWriter, r *http.Request) {
 	var data struct {
-		Name      string `json:"name"`
-		Email     string `json:"email"`
-		Country   string `json:"country"`
-		TreeType  string `json:"treeType"`
-		Years     int    `json:"years"`
-		PromoCode string `json:"promoCode"`
+		Name      string `json:"name" validate:"required,max=200"`
+		Email     string `json:"email" validate:"required,email"`
+		Country   string `json:"country" validate:"max=100"`
+		TreeType  string `json:"treeType" validate:"required,variety"`
+		Years     int    `json:"years" validate:"min=0,max=10"` // 0 means 1
+		PromoCode string `json:"promoCode" validate:"max=64"`
 		IsGift    bool   `json:"isGift"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &data) {
 		return
 	}
 
@@ -306,7 +544,7 @@ func handleAdopt(w http.ResponseWriter, r *http.Request) {
 	// Price Calculation
 	basePrice := 60.0
 	years := float64(data.Years)
-	
+
 	// Duration Discount
 	durationDiscount := 0.0
 	if data.Years == 2 {
@@ -314,7 +552,7 @@ func handleAdopt(w http.ResponseWriter, r *http.Request) {
 	} else if data.Years >= 3 {
 		durationDiscount = 0.15
 	}
-	
+
 	totalPrice := basePrice * years * (1.0 - durationDiscount)
 
 	// Validate Promo Code
@@ -337,12 +575,17 @@ func handleAdopt(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Insert customer
+	contactID, err := contactIDFor(db, data.Email, data.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	result, err := db.Exec(
-		"INSERT INTO customers (name, email, country, tree_type, status, newsletter_stage, years, promo_code, is_gift, amount_paid) VALUES (?, ?, ?, ?, 'interested', 'none', ?, ?, ?, ?)",
-		data.Name, data.Email, data.Country, data.TreeType, data.Years, data.PromoCode, data.IsGift, totalPrice)
+		"INSERT INTO customers (name, email, country, tree_type, status, newsletter_stage, years, promo_code, is_gift, amount_paid, locale, contact_id) VALUES (?, ?, ?, ?, 'interested', 'none', ?, ?, ?, ?, ?, ?)",
+		data.Name, data.Email, data.Country, data.TreeType, data.Years, data.PromoCode, data.IsGift, totalPrice, requestLocale(r), contactID)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -353,7 +596,7 @@ func handleAdopt(w http.ResponseWriter, r *http.Request) {
 	if data.IsGift {
 		// Generate a 100% discount off code
 		giftCode = fmt.Sprintf("GIFT-%d-%d", id, time.Now().Unix()%1000)
-		db.Exec("INSERT INTO promocodes (code, discount_percent, is_one_time, is_used) VALUES (?, 100, 1, 0)", giftCode)
+		db.Exec("INSERT INTO promocodes (code, discount_percent, is_one_time, is_used, customer_id) VALUES (?, 100, 1, 0, ?)", giftCode, id)
 		logActivity(id, "gift_generated", fmt.Sprintf("Generated gift code: %s", giftCode))
 		log.Printf("🎁 Gift Code Generated for %s: %s", data.Name, giftCode)
 	}
@@ -378,7 +621,7 @@ func handlePromoCodes(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		rows, err := db.Query("SELECT id, code, discount_percent, is_one_time, is_used, created_at FROM promocodes ORDER BY created_at DESC")
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 		defer rows.Close()
@@ -405,14 +648,16 @@ func handlePromoCodes(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(codes)
 	} else if r.Method == http.MethodPost {
 		var req struct {
-			Code     string `json:"code"`
-			Discount int    `json:"discount"`
+			Code     string `json:"code" validate:"required,max=64"`
+			Discount int    `json:"discount" validate:"min=1,max=100"`
 			OneTime  bool   `json:"oneTime"`
 		}
-		json.NewDecoder(r.Body).Decode(&req)
+		if !decodeJSON(w, r, &req) {
+			return
+		}
 		_, err := db.Exec("INSERT INTO promocodes (code, discount_percent, is_one_time) VALUES (?, ?, ?)", req.Code, req.Discount, req.OneTime)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 		json.NewEncoder(w).Encode(map[string]bool{"success": true})
@@ -421,9 +666,11 @@ func handlePromoCodes(w http.ResponseWriter, r *http.Request) {
 
 func handleValidatePromo(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Code string `json:"code"`
+		Code string `json:"code" validate:"required,max=64"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
 	}
-	json.NewDecoder(r.Body).Decode(&req)
 	var discount int
 	var isOneTime, isUsed bool
 	err := db.QueryRow("SELECT discount_percent, is_one_time, is_used FROM promocodes WHERE code = ?", req.Code).Scan(&discount, &isOneTime, &isUsed)
@@ -440,24 +687,19 @@ func handleValidatePromo(w http.ResponseWriter, r *http.Request) {
 
 // handleConfirmPayment simulates payment confirmation
 func handleConfirmPayment(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	var data struct {
-		CustomerID int64 `json:"customerId"`
+		CustomerID int64   `json:"customerId" validate:"min=1"`
+		AmountPaid float64 `json:"amountPaid"` // shown to the payer; the stored price is what counts
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &data) {
 		return
 	}
 
 	// MOCK: Update customer status to "paid"
 	_, err := db.Exec("UPDATE customers SET status = 'paid' WHERE id = ?", data.CustomerID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -496,40 +738,31 @@ func simulatePostPaymentAutomation(customerID int64) {
 
 // logActivity records an automation event
 func logActivity(customerID int64, action, message string) {
-	db.Exec("INSERT INTO activity_log (customer_id, action, message) VALUES (?, ?, ?)",
-		customerID, action, message)
-}
-
-func handleGetCustomers(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query(`
-		SELECT id, name, email, country, tree_type, status, newsletter_stage, created_at 
-		FROM customers ORDER BY created_at DESC`)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var customers []Customer
-	for rows.Next() {
-		var c Customer
-		rows.Scan(&c.ID, &c.Name, &c.Email, &c.Country, &c.TreeType, &c.Status, &c.NewsletterStage, &c.CreatedAt)
-		customers = append(customers, c)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(customers)
+	db.Exec(`INSERT INTO activity_log (customer_id, contact_id, action, message)
+		VALUES (?, (SELECT contact_id FROM customers WHERE id = ?), ?, ?)`,
+		customerID, customerID, action, message)
 }
 
-// handleGetActivity returns recent automation activity
+// handleGetActivity returns recent automation activity, everyone's or one
+// customer's with ?customerId=
 func handleGetActivity(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query(`
-		SELECT a.id, a.customer_id, a.action, a.message, a.created_at 
-		FROM activity_log a 
-		ORDER BY a.created_at DESC 
-		LIMIT 50`)
+	query := `
+		SELECT a.id, COALESCE(a.customer_id, 0), a.action, a.message, a.created_at 
+		FROM activity_log a`
+	var args []interface{}
+	if v := r.URL.Query().Get("customerId"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeFieldErrors(w, fieldErrors{"customerId": "must be a number"})
+			return
+		}
+		query += " WHERE a.customer_id = ?"
+		args = append(args, id)
+	}
+	query += " ORDER BY a.created_at DESC, a.id DESC LIMIT 50"
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	defer rows.Close()
@@ -575,18 +808,23 @@ func handleAdminFeedback(w http.ResponseWriter, r *http.Request) {
 func handleSlots(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		activity := r.URL.Query().Get("activity")
-		query := "SELECT id, activity, start_time, end_time, capacity, booked FROM slots WHERE start_time > CURRENT_TIMESTAMP"
+		query := `SELECT id, activity, start_time, end_time, capacity, booked, COALESCE(inquiry_id, 0), private_token IS NOT NULL, cancelled_at IS NOT NULL
+			FROM slots WHERE start_time > CURRENT_TIMESTAMP`
 		args := []interface{}{}
 
 		if activity != "" {
 			query += " AND activity = ?"
 			args = append(args, activity)
 		}
+		// Private and cancelled slots are only listed for the admin calendar (?all=1)
+		if r.URL.Query().Get("all") != "1" {
+			query += " AND private_token IS NULL AND cancelled_at IS NULL"
+		}
 		query += " ORDER BY start_time ASC"
 
 		rows, err := db.Query(query, args...)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 		defer rows.Close()
@@ -594,9 +832,10 @@ func handleSlots(w http.ResponseWriter, r *http.Request) {
 		var slots []Slot
 		for rows.Next() {
 			var s Slot
-			if err := rows.Scan(&s.ID, &s.Activity, &s.StartTime, &s.EndTime, &s.Capacity, &s.Booked); err != nil {
+			if err := rows.Scan(&s.ID, &s.Activity, &s.StartTime, &s.EndTime, &s.Capacity, &s.Booked, &s.InquiryID, &s.Private, &s.Cancelled); err != nil {
 				continue
 			}
+			s.localize()
 			slots = append(slots, s)
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -607,129 +846,222 @@ func handleSlots(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		// Create new slot(s)
 		var req struct {
-			Activity        string `json:"activity"`
-			StartTime       string `json:"startTime"` // ISO string
-			Capacity        int    `json:"capacity"`
-			DurationMinutes int    `json:"durationMinutes"`
-			IsRecurring     bool   `json:"isRecurring"`
-			RecurWeeks      int    `json:"recurWeeks"` // Number of weeks to repeat
-			RecurDays       []int  `json:"recurDays"`  // 0=Sunday, 1=Monday...
+			Activity        string   `json:"activity" validate:"required"`
+			StartTime       string   `json:"startTime" validate:"required"` // RFC3339 with offset, or naive farm time
+			Capacity        int      `json:"capacity" validate:"min=0"`
+			DurationMinutes int      `json:"durationMinutes" validate:"min=0,max=1440"`
+			IsRecurring     bool     `json:"isRecurring"`
+			RecurWeeks      int      `json:"recurWeeks" validate:"min=0,max=104"` // Number of weeks to repeat
+			RecurDays       []int    `json:"recurDays"`                           // 0=Sunday, 1=Monday...
+			ResourceIDs     []int64  `json:"resourceIds"`                         // Defaults to the activity's resources
+			RRule           string   `json:"rrule" validate:"max=500"`            // RFC 5545, e.g. FREQ=WEEKLY;BYDAY=SA;UNTIL=20270831
+			ExDates         []string `json:"exdates" validate:"max=500"`          // Skipped dates, YYYY-MM-DD or RFC3339
 		}
 
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 
 		// Parse StartTime
-		start, err := time.Parse(time.RFC3339, req.StartTime)
+		start, err := parseFarmTime(req.StartTime)
 		if err != nil {
-			http.Error(w, "Invalid date format: "+err.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "Invalid date format: "+err.Error())
 			return
 		}
 
-		duration := time.Duration(req.DurationMinutes) * time.Minute
-		if duration == 0 {
-			duration = 90 * time.Minute
-		} // Default
+		activity, err := getActivityBySlug(db, req.Activity)
+		if err != nil || !activity.Active {
+			writeError(w, http.StatusBadRequest, "Unknown or inactive activity: "+req.Activity)
+			return
+		}
 
-		// Helper to insert slot
-		insertSlot := func(t time.Time) error {
-			end := t.Add(duration)
-			_, err := db.Exec("INSERT INTO slots (activity, start_time, end_time, capacity) VALUES (?, ?, ?, ?)",
-				req.Activity, t.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05"), req.Capacity)
-			return err
+		// Defaults come from the activity catalog
+		if req.DurationMinutes <= 0 {
+			req.DurationMinutes = activity.DefaultDurationMinutes
 		}
+		if req.Capacity <= 0 {
+			req.Capacity = activity.DefaultCapacity
+		}
+		if req.ResourceIDs == nil {
+			req.ResourceIDs = activity.ResourceIDs
+		}
+		if err := validResourceIDs(db, req.ResourceIDs); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		duration := time.Duration(req.DurationMinutes) * time.Minute
 
-		if !req.IsRecurring {
-			if err := insertSlot(start); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+		if !req.IsRecurring && req.RRule == "" {
+			id, conflicts, err := createSlot(activity, start, duration, req.Capacity, req.ResourceIDs, slotOrigin{})
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
 				return
 			}
-		} else {
-			// Recurring logic
-
-			// If RecurDays is empty, assume just repeating existing date for N weeks
-			targetDays := make(map[time.Weekday]bool)
-			for _, d := range req.RecurDays {
-				targetDays[time.Weekday(d)] = true
+			if len(conflicts) > 0 {
+				writeErrorDetails(w, http.StatusConflict, "slot_conflict", "Cannot create the slot: "+conflictMessage(conflicts),
+					map[string]interface{}{"created": []int64{}, "conflicts": conflicts})
+				return
 			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "created": []int64{id}, "conflicts": []SlotConflict{}})
+			return
+		}
 
-			// Iterate day by day for (RecurWeeks * 7) days to find matches
-			baseTime := start
-			// If only repeating same day every week
-			if len(targetDays) == 0 {
-				for i := 0; i < req.RecurWeeks; i++ {
-					// Add i weeks
-					nextDate := baseTime.AddDate(0, 0, i*7)
-					if err := insertSlot(nextDate); err != nil {
-						log.Println("Error creating recurring slot:", err)
-					}
-				}
-			} else {
-				// If specific days selected (e.g. Mon, Wed)
-				// Start from baseTime and go forward
-				for i := 0; i < req.RecurWeeks*7; i++ {
-					currentDay := baseTime.AddDate(0, 0, i)
-					if targetDays[currentDay.Weekday()] {
-						if err := insertSlot(currentDay); err != nil {
-							log.Println("Error creating recurring slot:", err)
-						}
-					}
-				}
-			}
+		// Recurring: the legacy week/day fields are translated into an RRULE
+		ruleText := req.RRule
+		if ruleText == "" {
+			ruleText = legacyRecurrenceRule(start, req.RecurWeeks, req.RecurDays)
+		}
+		rule, err := parseRRule(ruleText)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid recurrence rule: "+err.Error())
+			return
 		}
 
-		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+		seriesID, created, conflicts, err := createSeries(activity, start, duration, req.Capacity, rule, req.ExDates, req.ResourceIDs)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if len(created) == 0 && len(conflicts) > 0 {
+			writeErrorDetails(w, http.StatusConflict, "slot_conflict", "No occurrence could be created: "+conflictMessage(conflicts),
+				map[string]interface{}{"seriesId": seriesID, "created": created, "conflicts": conflicts})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   len(conflicts) == 0,
+			"seriesId":  seriesID,
+			"created":   created,
+			"conflicts": conflicts,
+		})
 	}
 }
 
-func handleBookVisit(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleSlot returns one slot by id, private and cancelled ones included
+func handleSlot(w http.ResponseWriter, r *http.Request) {
+	var s Slot
+	err := db.QueryRow(`SELECT id, activity, start_time, end_time, capacity, booked, COALESCE(inquiry_id, 0), private_token IS NOT NULL, cancelled_at IS NOT NULL
+		FROM slots WHERE id = ?`, r.PathValue("id")).
+		Scan(&s.ID, &s.Activity, &s.StartTime, &s.EndTime, &s.Capacity, &s.Booked, &s.InquiryID, &s.Private, &s.Cancelled)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "Slot not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	s.localize()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+func handleBookVisit(w http.ResponseWriter, r *http.Request) {
 	var b Booking
-	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &b) {
+		return
+	}
+
+	// Older clients only send a plain quantity, treat those as adult tickets
+	if b.Tickets.Total() == 0 {
+		b.Tickets.Adult = b.Quantity
+	}
+	b.Quantity = b.Tickets.Total()
+	if b.Quantity < 1 {
+		writeFieldErrors(w, fieldErrors{"tickets": "must include at least 1 ticket"})
 		return
 	}
 
 	// Transaction to check capacity and book
 	tx, err := db.Begin()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	var capacity, booked int
-	err = tx.QueryRow("SELECT capacity, booked FROM slots WHERE id = ?", b.SlotID).Scan(&capacity, &booked)
+	var activitySlug string
+	var privateToken sql.NullString
+	var cancelled bool
+	err = tx.QueryRow("SELECT activity, capacity, booked, private_token, cancelled_at IS NOT NULL FROM slots WHERE id = ?", b.SlotID).Scan(&activitySlug, &capacity, &booked, &privateToken, &cancelled)
+	// Private slots look like they don't exist without their link token
+	if err != nil || (privateToken.Valid && privateToken.String != b.SlotToken) {
+		tx.Rollback()
+		writeError(w, http.StatusNotFound, "Slot not found")
+		return
+	}
+	if cancelled {
+		tx.Rollback()
+		writeError(w, http.StatusGone, "This time has been cancelled")
+		return
+	}
+
+	activity, err := getActivityBySlug(tx, activitySlug)
 	if err != nil {
 		tx.Rollback()
-		http.Error(w, "Slot not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, "Activity not found: "+activitySlug)
 		return
 	}
 
-	if booked+b.Quantity > capacity {
+	total, err := activity.Price(b.Tickets)
+	if err != nil {
 		tx.Rollback()
-		http.Error(w, "Not enough capacity", http.StatusConflict)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Seats offered to the waitlist are off limits unless this is that offer
+	var waitlistID int64
+	if b.WaitlistToken != "" {
+		err = tx.QueryRow("SELECT id FROM waitlist WHERE offer_token = ? AND slot_id = ? AND status = 'offered' AND offer_expires_at > ?",
+			b.WaitlistToken, b.SlotID, dbTime(time.Now())).Scan(&waitlistID)
+		if err != nil {
+			tx.Rollback()
+			writeError(w, http.StatusGone, "Waitlist offer has expired")
+			return
+		}
+	}
+	held := heldSeats(tx, b.SlotID, b.WaitlistToken)
+
+	if booked+held+b.Quantity > capacity {
+		tx.Rollback()
+		writeError(w, http.StatusConflict, "Not enough capacity")
 		return
 	}
 
 	_, err = tx.Exec("UPDATE slots SET booked = booked + ? WHERE id = ?", b.Quantity, b.SlotID)
 	if err != nil {
 		tx.Rollback()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	contactID, err := contactIDFor(tx, b.CustomerEmail, b.CustomerName)
+	if err != nil {
+		tx.Rollback()
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	res, err := tx.Exec("INSERT INTO bookings (slot_id, customer_name, customer_email, quantity, status) VALUES (?, ?, ?, ?, 'pending')", b.SlotID, b.CustomerName, b.CustomerEmail, b.Quantity)
+	accessToken := newToken()
+	res, err := tx.Exec(`INSERT INTO bookings (slot_id, customer_name, customer_email, quantity, qty_adult, qty_child, qty_senior, total_amount, status, access_token, locale, contact_id, hold_expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'pending', ?, ?, ?, ?)`,
+		b.SlotID, b.CustomerName, b.CustomerEmail, b.Quantity, b.Tickets.Adult, b.Tickets.Child, b.Tickets.Senior, total, accessToken, requestLocale(r), contactID,
+		dbTime(time.Now().Add(bookingHoldTTL())))
 	if err != nil {
 		tx.Rollback()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	bookingID, _ := res.LastInsertId()
+	if waitlistID != 0 {
+		if _, err := tx.Exec("UPDATE waitlist SET status = 'booked', booking_id = ? WHERE id = ?", bookingID, waitlistID); err != nil {
+			tx.Rollback()
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
 	tx.Commit()
 
 	// In a real app, we'd redirect to generic payment with booking ID
@@ -739,23 +1071,27 @@ func handleBookVisit(w http.ResponseWriter, r *http.Request) {
 		"id":      bookingID, // Booking ID
 		"name":    b.CustomerName,
 		// Reuse 'treeType' param as 'activity' description or similar
-		"treeType": "Visit Booking #" + fmt.Sprintf("%d", bookingID),
+		"treeType":    "Visit Booking #" + fmt.Sprintf("%d", bookingID),
+		"amount":      total,
+		"tickets":     b.Tickets,
+		"accessToken": accessToken, // used for the .ics download
 	})
 }
 
 func handleInquiry(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	var inq Inquiry
+	if !decodeJSON(w, r, &inq) {
 		return
 	}
-	var inq Inquiry
-	if err := json.NewDecoder(r.Body).Decode(&inq); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	contactID, err := contactIDFor(db, inq.Email, inq.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	_, err := db.Exec("INSERT INTO inquiries (name, email, activity, proposed_date, message) VALUES (?, ?, ?, ?, ?)", inq.Name, inq.Email, inq.Activity, inq.ProposedDate, inq.Message)
+	_, err = db.Exec("INSERT INTO inquiries (name, email, activity, proposed_date, message, locale, contact_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		inq.Name, inq.Email, inq.Activity, inq.ProposedDate, inq.Message, requestLocale(r), contactID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
@@ -765,7 +1101,7 @@ func handleAdminVisits(w http.ResponseWriter, r *http.Request) {
 	// Fetch Slots (for list view if needed, but calendar uses API)
 	rows, err := db.Query("SELECT id, activity, start_time, end_time, capacity, booked FROM slots ORDER BY start_time DESC")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	defer rows.Close()
@@ -774,6 +1110,7 @@ func handleAdminVisits(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var s Slot
 		rows.Scan(&s.ID, &s.Activity, &s.StartTime, &s.EndTime, &s.Capacity, &s.Booked)
+		s.localize()
 		slots = append(slots, s)
 	}
 
@@ -794,118 +1131,181 @@ func handleAdminVisits(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	activities, err := listActivities(false)
+	if err != nil {
+		log.Println("Error fetching activities:", err)
+	}
+
+	waitlist, err := listWaitlist(0)
+	if err != nil {
+		log.Println("Error fetching waitlist:", err)
+	}
+
+	now := time.Now()
+	alerts, err := weatherAlerts(now, now.AddDate(0, 0, 7))
+	if err != nil {
+		log.Println("Error fetching weather forecast:", err)
+	}
+
 	data := struct {
-		Title     string
-		Slots     []Slot
-		Inquiries []Inquiry
+		Title         string
+		Slots         []Slot
+		Inquiries     []Inquiry
+		Activities    []Activity
+		Waitlist      []WaitlistEntry
+		WeatherAlerts []WeatherAlert
 	}{
-		Title:     "Manage Visits",
-		Slots:     slots,
-		Inquiries: inquiries,
+		Title:         "Manage Visits",
+		Slots:         slots,
+		Inquiries:     inquiries,
+		Activities:    activities,
+		Waitlist:      waitlist,
+		WeatherAlerts: alerts,
 	}
 	tmpl.ExecuteTemplate(w, "admin-visits.html", data)
 }
 
 func handleInquiryAction(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	var req struct {
-		ID       int64     `json:"id"`
-		Action   string    `json:"action"` // "accept" or "decline"
+		ID       int64     `json:"id" validate:"required"`
+		Action   string    `json:"action" validate:"required,oneof=accept|decline"`
+		Note     string    `json:"note" validate:"max=2000"` // optional personal line in the decline email
 		SlotData *struct { // If accept, create a slot
-			Activity  string `json:"activity"`
-			StartTime string `json:"startTime"`
-			Capacity  int    `json:"capacity"`
+			Activity  string `json:"activity" validate:"max=100"`
+			StartTime string `json:"startTime" validate:"required"`
+			Capacity  int    `json:"capacity" validate:"min=0"`
 		} `json:"slotData"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	var inq Inquiry
+	err := db.QueryRow("SELECT id, name, email, activity, proposed_date, message, status, COALESCE(locale, ''), created_at FROM inquiries WHERE id = ?", req.ID).
+		Scan(&inq.ID, &inq.Name, &inq.Email, &inq.Activity, &inq.ProposedDate, &inq.Message, &inq.Status, &inq.Locale, &inq.CreatedAt)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Inquiry not found")
+		return
+	}
+	if inq.Status != "pending" {
+		writeError(w, http.StatusConflict, "Inquiry is already "+inq.Status)
 		return
 	}
 
 	if req.Action == "decline" {
-		_, err := db.Exec("UPDATE inquiries SET status = 'declined' WHERE id = ?", req.ID)
+		alternatives, err := suggestAlternativeSlots(inq, 3)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			log.Printf("Error finding alternatives for inquiry %d: %v", inq.ID, err)
 		}
-		// TODO: Send email notification
-	} else if req.Action == "accept" {
-		if req.SlotData != nil {
-			// Create Slot
-			duration := 90 * time.Minute
-			// StartTime from frontend is likely "YYYY-MM-DD HH:MM"
-			start, err := time.Parse("2006-01-02 15:04", req.SlotData.StartTime)
-			if err != nil {
-				// Fallback to ISO just in case
-				start, err = time.Parse("2006-01-02T15:04", req.SlotData.StartTime)
-			}
-			if err != nil {
-				http.Error(w, "Invalid date format: "+err.Error(), http.StatusBadRequest)
-				return
-			}
-			end := start.Add(duration)
-
-			_, err = db.Exec("INSERT INTO slots (activity, start_time, end_time, capacity) VALUES (?, ?, ?, ?)",
-				req.SlotData.Activity, start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05"), req.SlotData.Capacity)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-		}
-
-		_, err := db.Exec("UPDATE inquiries SET status = 'accepted' WHERE id = ?", req.ID)
+		_, err = db.Exec("UPDATE inquiries SET status = 'declined' WHERE id = ?", req.ID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		// TODO: Send email notification
+		sendInquiryDeclined(inq, alternatives, req.Note)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "alternatives": alternatives})
+		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
-}
+	if req.SlotData == nil {
+		writeFieldErrors(w, fieldErrors{"slotData": "is required to accept an inquiry"})
+		return
+	}
 
-func handleConfirmVisit(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if req.SlotData.Activity == "" {
+		req.SlotData.Activity = inq.Activity
+	}
+	activity, err := getActivityBySlug(db, req.SlotData.Activity)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Unknown activity: "+req.SlotData.Activity)
+		return
+	}
+	// Create Slot with the activity's default duration
+	duration := time.Duration(activity.DefaultDurationMinutes) * time.Minute
+	if req.SlotData.Capacity <= 0 {
+		req.SlotData.Capacity = activity.DefaultCapacity
+	}
+	// StartTime from frontend is likely "YYYY-MM-DD HH:MM" in farm time
+	start, err := parseFarmTime(req.SlotData.StartTime)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid date format: "+err.Error())
 		return
 	}
 
+	// The slot is private: only the requester gets the link to book it
+	token := newToken()
+	slotID, conflicts, err := createSlot(activity, start, duration, req.SlotData.Capacity, activity.ResourceIDs, slotOrigin{InquiryID: inq.ID, PrivateToken: token})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(conflicts) > 0 {
+		writeErrorDetails(w, http.StatusConflict, "slot_conflict", "Cannot create the private slot: "+conflictMessage(conflicts),
+			map[string]interface{}{"conflicts": conflicts})
+		return
+	}
+
+	_, err = db.Exec("UPDATE inquiries SET status = 'accepted' WHERE id = ?", req.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	bookingURL := sendInquiryAccepted(inq, activity, start, token)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "slotId": slotID, "bookingUrl": bookingURL})
+}
+
+func handleConfirmVisit(w http.ResponseWriter, r *http.Request) {
 	var data struct {
-		BookingID int64 `json:"bookingId"`
+		BookingID  int64   `json:"bookingId" validate:"min=1"`
+		AmountPaid float64 `json:"amountPaid"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &data) {
 		return
 	}
 
 	tx, err := db.Begin()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Update booking status
-	_, err = tx.Exec("UPDATE bookings SET status = 'paid' WHERE id = ?", data.BookingID)
+	// Update booking status. Holds that expired have already given their seats away.
+	res, err := tx.Exec("UPDATE bookings SET status = 'paid' WHERE id = ? AND status IN ('pending', 'paid')", data.BookingID)
 	if err != nil {
 		tx.Rollback()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		writeError(w, http.StatusGone, "Booking has expired or was cancelled")
+		return
+	}
+
+	// Paid bookings get a QR ticket for check-in
+	if _, err := tx.Exec("UPDATE bookings SET ticket_code = COALESCE(ticket_code, ?) WHERE id = ?", newTicketCode(), data.BookingID); err != nil {
+		tx.Rollback()
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// Get booking details for log
-	var customerName, customerEmail, activity string
+	var customerName, customerEmail, activity, accessToken, locale string
 	var quantity int
+	var totalAmount float64
+	var contactID int64
 	err = tx.QueryRow(`
-		SELECT b.customer_name, b.customer_email, b.quantity, s.activity 
+		SELECT b.customer_name, b.customer_email, b.quantity, b.total_amount, s.activity, COALESCE(b.access_token, ''), COALESCE(b.locale, ''),
+			COALESCE(b.contact_id, 0)
 		FROM bookings b 
 		JOIN slots s ON b.slot_id = s.id 
-		WHERE b.id = ?`, data.BookingID).Scan(&customerName, &customerEmail, &quantity, &activity)
+		WHERE b.id = ?`, data.BookingID).Scan(&customerName, &customerEmail, &quantity, &totalAmount, &activity, &accessToken, &locale, &contactID)
 
 	if err != nil {
 		// Log error but don't fail the transaction just for this
@@ -914,19 +1314,25 @@ func handleConfirmVisit(w http.ResponseWriter, r *http.Request) {
 
 	tx.Commit()
 
-	// MOCK: Log payment activity
-	msg := fmt.Sprintf("Visit confirmed: %s booked %s for %d pax", customerName, activity, quantity)
-	// We can use 0 for customer_id or make it nullable in activity_log,
-	// OR create a customer record for them?
-	// For now, let's just log to console as we might not have a customer ID in 'customers' table for visits yet.
-	// If we want to reuse activity log, we need a customer ID.
-	// Let's just skip activity_log insert for now to avoid FK constraint issues if 0 is not allowed.
+	msg := fmt.Sprintf("Visit confirmed: %s booked %s for %d pax, paid €%.2f", customerName, activity, quantity, totalAmount)
+	logContactActivity(contactID, 0, "payment", msg)
 	log.Printf("💳 %s", msg)
+	locale = preferredLocale(customerEmail, locale)
+	sendCustomerEmail(customerEmail, locale, "booking_confirmed", map[string]interface{}{
+		"Name":        customerName,
+		"Activity":    activityNameIn(activity, locale),
+		"Quantity":    quantity,
+		"TicketURL":   publicURL("/ticket.html?token=" + accessToken),
+		"CalendarURL": publicURL("/api/bookings/ics?token=" + accessToken),
+		"CancelURL":   publicURL("/book-visit.html?cancel=" + accessToken),
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": "Visit Payment confirmed!",
+		"success":   true,
+		"message":   "Visit Payment confirmed!",
+		"icsUrl":    "/api/bookings/ics?token=" + accessToken,
+		"ticketUrl": "/ticket.html?token=" + accessToken,
 	})
 }
 
@@ -934,7 +1340,7 @@ func handleNewsletters(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		rows, err := db.Query("SELECT id, subject, content, filter_criteria, status, created_at, sent_at FROM newsletters ORDER BY created_at DESC")
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 		defer rows.Close()
@@ -958,10 +1364,20 @@ func handleNewsletters(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method == http.MethodPost {
 		var n Newsletter
-		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if !decodeJSON(w, r, &n) {
+			return
+		}
+
+		// The content is sent to customers as HTML, so only allowlisted markup
+		// is stored. Rather than silently changing what staff wrote, say what
+		// would be removed.
+		clean, stripped := sanitizeHTML(n.Content)
+		if len(stripped) > 0 {
+			writeErrorDetails(w, http.StatusBadRequest, "disallowed_html", strippedError("The newsletter", stripped),
+				map[string]interface{}{"stripped": stripped})
 			return
 		}
+		n.Content = clean
 
 		// Check if it's a send action
 		if r.URL.Query().Get("action") == "send" {
@@ -971,16 +1387,17 @@ func handleNewsletters(w http.ResponseWriter, r *http.Request) {
 
 			// Update status if it's an existing newsletter being sent
 			if n.ID != 0 {
-				_, err := db.Exec("UPDATE newsletters SET status = 'sent', sent_at = CURRENT_TIMESTAMP WHERE id = ?", n.ID)
+				_, err := db.Exec("UPDATE newsletters SET subject = ?, content = ?, filter_criteria = ?, status = 'sent', sent_at = CURRENT_TIMESTAMP WHERE id = ?",
+					n.Subject, n.Content, n.FilterCriteria, n.ID)
 				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
+					writeError(w, http.StatusInternalServerError, err.Error())
 					return
 				}
 			} else {
 				// Save as sent immediately
 				_, err := db.Exec("INSERT INTO newsletters (subject, content, filter_criteria, status, sent_at) VALUES (?, ?, ?, 'sent', CURRENT_TIMESTAMP)", n.Subject, n.Content, n.FilterCriteria)
 				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
+					writeError(w, http.StatusInternalServerError, err.Error())
 					return
 				}
 			}
@@ -993,13 +1410,13 @@ func handleNewsletters(w http.ResponseWriter, r *http.Request) {
 		if n.ID != 0 {
 			_, err := db.Exec("UPDATE newsletters SET subject=?, content=?, filter_criteria=? WHERE id=?", n.Subject, n.Content, n.FilterCriteria, n.ID)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeError(w, http.StatusInternalServerError, err.Error())
 				return
 			}
 		} else {
 			_, err := db.Exec("INSERT INTO newsletters (subject, content, filter_criteria) VALUES (?, ?, ?)", n.Subject, n.Content, n.FilterCriteria)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeError(w, http.StatusInternalServerError, err.Error())
 				return
 			}
 		}
@@ -1021,3 +1438,7 @@ func handleAdminDashboard(w http.ResponseWriter, r *http.Request) {
 func handleAdminTrees(w http.ResponseWriter, r *http.Request) {
 	tmpl.ExecuteTemplate(w, "admin-trees.html", nil)
 }
+
+func handleAdminShop(w http.ResponseWriter, r *http.Request) {
+	tmpl.ExecuteTemplate(w, "admin-shop.html", nil)
+}
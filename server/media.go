@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Media is an uploaded image in the media library. The original is never
+// kept: every variant is re-encoded from the decoded pixels, so EXIF data
+// (GPS position, camera serials) doesn't survive the upload.
+type Media struct {
+	ID           int64             `json:"id"`
+	OriginalName string            `json:"originalName"`
+	ContentType  string            `json:"contentType"` // of the stored variants
+	Width        int               `json:"width"`
+	Height       int               `json:"height"`
+	Bytes        int               `json:"bytes"` // size of the upload
+	Alt          string            `json:"alt"`
+	URL          string            `json:"url"`      // the large variant, for pages and emails
+	Variants     map[string]string `json:"variants"` // size name -> URL
+	StaffID      int64             `json:"staffId,omitempty"`
+	CreatedAt    string            `json:"createdAt"`
+}
+
+// mediaVariants are the sizes generated for each upload, by longest side.
+// Images smaller than a size are stored as they are rather than upscaled.
+var mediaVariants = []struct {
+	Name string
+	Max  int
+}{
+	{"thumb", 320},
+	{"medium", 800},
+	{"large", 1600},
+}
+
+const (
+	mediaSelectSQL = `SELECT id, token, COALESCE(original_name, ''), content_type, width, height, bytes, COALESCE(alt, ''),
+		COALESCE(staff_id, 0), created_at FROM media`
+	maxMediaPixels = 40_000_000 // rejects decompression bombs before decoding
+)
+
+func initMediaTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS media (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		token TEXT UNIQUE,
+		original_name TEXT,
+		content_type TEXT,
+		width INTEGER,
+		height INTEGER,
+		bytes INTEGER,
+		alt TEXT,
+		staff_id INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(staff_id) REFERENCES staff(id)
+	);`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating media tables: %v", err)
+	}
+}
+
+// uploadDir is where uploaded files are stored. It sits under public/ so
+// the static file server hands them out.
+func uploadDir() string {
+	if dir := os.Getenv("UPLOAD_DIR"); dir != "" {
+		return dir
+	}
+	return "./public/uploads"
+}
+
+// maxMediaBytes is the largest upload accepted (MEDIA_MAX_MB, default 10)
+func maxMediaBytes() int64 {
+	if mb, err := strconv.Atoi(os.Getenv("MEDIA_MAX_MB")); err == nil && mb > 0 {
+		return int64(mb) << 20
+	}
+	return 10 << 20
+}
+
+func mediaExt(contentType string) string {
+	if contentType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+func mediaURL(token, variant, contentType string) string {
+	return "/uploads/media/" + token + "-" + variant + mediaExt(contentType)
+}
+
+func scanMedia(row interface{ Scan(...interface{}) error }) (*Media, error) {
+	var m Media
+	var token string
+	if err := row.Scan(&m.ID, &token, &m.OriginalName, &m.ContentType, &m.Width, &m.Height, &m.Bytes, &m.Alt,
+		&m.StaffID, &m.CreatedAt); err != nil {
+		return nil, err
+	}
+	m.Variants = map[string]string{}
+	for _, v := range mediaVariants {
+		m.Variants[v.Name] = mediaURL(token, v.Name, m.ContentType)
+	}
+	m.URL = m.Variants["large"]
+	m.CreatedAt = farmTime(m.CreatedAt)
+	return &m, nil
+}
+
+func getMedia(id int64) (*Media, error) {
+	return scanMedia(db.QueryRow(mediaSelectSQL+" WHERE id = ?", id))
+}
+
+// saveMedia validates an uploaded image, writes its variants and adds it to
+// the media library. Only JPEG, PNG and WebP are accepted; PNGs stay PNG so
+// transparency survives, everything else is stored as JPEG.
+func saveMedia(file io.Reader, originalName, alt string, staffID int64) (*Media, error) {
+	limit := maxMediaBytes()
+	data, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("images can be at most %d MB", limit>>20)
+	}
+	detected := http.DetectContentType(data)
+	if detected != "image/jpeg" && detected != "image/png" && detected != "image/webp" {
+		return nil, fmt.Errorf("images must be JPEG, PNG or WebP")
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the image: %v", err)
+	}
+	if cfg.Width*cfg.Height > maxMediaPixels {
+		return nil, fmt.Errorf("image is too large (%dx%d)", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the image: %v", err)
+	}
+	if detected == "image/jpeg" {
+		img = applyOrientation(img, exifOrientation(data))
+	}
+
+	contentType := "image/jpeg"
+	if detected == "image/png" {
+		contentType = "image/png"
+	}
+	dir := filepath.Join(uploadDir(), "media")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	token := newToken()
+	var written []string
+	for _, v := range mediaVariants {
+		path := filepath.Join(uploadDir(), strings.TrimPrefix(mediaURL(token, v.Name, contentType), "/uploads/"))
+		if err := writeVariant(path, resizeToFit(img, v.Max), contentType); err != nil {
+			removeFiles(written)
+			return nil, err
+		}
+		written = append(written, path)
+	}
+
+	bounds := img.Bounds()
+	var staff interface{}
+	if staffID != 0 {
+		staff = staffID
+	}
+	res, err := db.Exec("INSERT INTO media (token, original_name, content_type, width, height, bytes, alt, staff_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		token, filepath.Base(originalName), contentType, bounds.Dx(), bounds.Dy(), len(data), alt, staff, dbTime(time.Now()))
+	if err != nil {
+		removeFiles(written)
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	log.Printf("🖼️ Media #%d uploaded: %s (%dx%d)", id, originalName, bounds.Dx(), bounds.Dy())
+	return getMedia(id)
+}
+
+// saveMediaFiles stores every file of a multipart field. If one fails the
+// ones already stored are removed again.
+func saveMediaFiles(files []*multipart.FileHeader, alt string, staffID int64) ([]Media, error) {
+	saved := []Media{}
+	for _, fh := range files {
+		f, err := fh.Open()
+		if err != nil {
+			deleteMediaItems(saved)
+			return nil, err
+		}
+		m, err := saveMedia(f, fh.Filename, alt, staffID)
+		f.Close()
+		if err != nil {
+			deleteMediaItems(saved)
+			return nil, fmt.Errorf("%s: %v", fh.Filename, err)
+		}
+		saved = append(saved, *m)
+	}
+	return saved, nil
+}
+
+func deleteMediaItems(items []Media) {
+	for _, m := range items {
+		deleteMedia(&m)
+	}
+}
+
+func deleteMedia(m *Media) error {
+	if _, err := db.Exec("DELETE FROM media WHERE id = ?", m.ID); err != nil {
+		return err
+	}
+	for _, url := range m.Variants {
+		os.Remove(filepath.Join(uploadDir(), strings.TrimPrefix(url, "/uploads/")))
+	}
+	return nil
+}
+
+func removeFiles(paths []string) {
+	for _, p := range paths {
+		os.Remove(p)
+	}
+}
+
+// mediaUsage lists where an image is referenced, so it isn't deleted from
+// under a product, diary post, newsletter or CMS field like the hero image
+func mediaUsage(m *Media) []string {
+	token := strings.TrimSuffix(strings.TrimPrefix(m.Variants["large"], "/uploads/media/"), "-large"+mediaExt(m.ContentType))
+	pattern := "%/uploads/media/" + token + "-%"
+	var used []string
+	sources := []struct{ label, query string }{
+		{"product %s", "SELECT sku FROM products WHERE images LIKE ?"},
+		{"diary post #%s", "SELECT id FROM diary_posts WHERE photos LIKE ?"},
+		{"newsletter #%s", "SELECT id FROM newsletters WHERE content LIKE ?"},
+		{"page text %s", "SELECT key FROM site_content WHERE value LIKE ?"},
+		{"page text %s", "SELECT key || ' (' || locale || ')' FROM site_content_translations WHERE value LIKE ?"},
+	}
+	for _, s := range sources {
+		rows, err := db.Query(s.query, pattern)
+		if err != nil {
+			continue
+		}
+		for rows.Next() {
+			var ref string
+			if rows.Scan(&ref) == nil {
+				used = append(used, fmt.Sprintf(s.label, ref))
+			}
+		}
+		rows.Close()
+	}
+	return used
+}
+
+func resizeToFit(img image.Image, longest int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= longest && h <= longest {
+		return img
+	}
+	if w >= h {
+		h, w = h*longest/w, longest
+	} else {
+		w, h = w*longest/h, longest
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+func writeVariant(path string, img image.Image, contentType string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if contentType == "image/png" {
+		err = png.Encode(f, img)
+	} else {
+		// JPEG has no alpha channel, so transparent WebPs go on white
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		err = jpeg.Encode(f, flat, &jpeg.Options{Quality: 85})
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// exifOrientation reads the orientation tag (1-8) from a JPEG's EXIF
+// segment. Phones store photos sideways and rely on this tag, which would
+// otherwise be lost with the rest of the EXIF data.
+func exifOrientation(data []byte) int {
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || size < 2 || i+2+size > len(data) {
+			break
+		}
+		seg := data[i+4 : i+2+size]
+		if marker == 0xE1 && len(seg) > 14 && string(seg[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(seg[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		e := ifd + 2 + n*12
+		if e+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[e:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+		}
+	}
+	return 1
+}
+
+// applyOrientation turns the pixels the way the EXIF orientation says the
+// photo should be shown
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var dst *image.NRGBA
+	if orientation >= 5 {
+		dst = image.NewNRGBA(image.Rect(0, 0, h, w))
+	} else {
+		dst = image.NewNRGBA(image.Rect(0, 0, w, h))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // upside down, mirrored
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// handleMedia is the media library and picker API.
+// GET lists images, newest first (?q= searches name and alt text, ?before=
+// pages by id, ?id= returns one). POST (multipart "file", optional "alt")
+// uploads, PUT ?id= {"alt"} edits the alt text and DELETE ?id= removes an
+// image that isn't in use. Changes need a staff key.
+func handleMedia(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+
+	switch r.Method {
+	case http.MethodGet:
+		if id != 0 {
+			m, err := getMedia(id)
+			if err != nil {
+				writeError(w, http.StatusNotFound, "Image not found")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(m)
+			return
+		}
+
+		where, args := []string{"1 = 1"}, []interface{}{}
+		if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+			where = append(where, "(original_name LIKE ? OR alt LIKE ?)")
+			args = append(args, "%"+q+"%", "%"+q+"%")
+		}
+		if before, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64); before != 0 {
+			where = append(where, "id < ?")
+			args = append(args, before)
+		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit <= 0 || limit > 200 {
+			limit = 60
+		}
+		rows, err := db.Query(mediaSelectSQL+" WHERE "+strings.Join(where, " AND ")+" ORDER BY id DESC LIMIT "+strconv.Itoa(limit), args...)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer rows.Close()
+		items := []Media{}
+		for rows.Next() {
+			m, err := scanMedia(rows)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			items = append(items, *m)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+
+	case http.MethodPost:
+		staffID, ok := requireStaff(w, r)
+		if !ok {
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, 4*maxMediaBytes())
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			writeError(w, http.StatusBadRequest, "Expected a multipart form: "+err.Error())
+			return
+		}
+		files := r.MultipartForm.File["file"]
+		if len(files) == 0 {
+			writeError(w, http.StatusBadRequest, "No file uploaded")
+			return
+		}
+		saved, err := saveMediaFiles(files, strings.TrimSpace(r.FormValue("alt")), staffID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "media": saved})
+
+	case http.MethodPut:
+		if _, ok := requireStaff(w, r); !ok {
+			return
+		}
+		var req struct {
+			Alt string `json:"alt" validate:"max=500"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		res, err := db.Exec("UPDATE media SET alt = ? WHERE id = ?", strings.TrimSpace(req.Alt), id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, http.StatusNotFound, "Image not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	case http.MethodDelete:
+		if _, ok := requireStaff(w, r); !ok {
+			return
+		}
+		m, err := getMedia(id)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if used := mediaUsage(m); len(used) > 0 {
+			writeError(w, http.StatusConflict, "Image is used by "+strings.Join(used, ", "))
+			return
+		}
+		if err := deleteMedia(m); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Server-rendered pages. Every page template defines "content" for
+// base.html, so each one is parsed on its own together with base.html
+// instead of sharing tmpl, where the last "content" would win.
+
+var pageTemplates = map[string]*template.Template{}
+
+// page is one template-rendered route. data adds the page's own fields to
+// the Locale, Path and Title every page gets.
+type page struct {
+	template string
+	title    string // locales/ key
+	data     func(r *http.Request, locale string) (map[string]interface{}, error)
+}
+
+var pages = map[string]page{
+	"/":                    {"frontpage.html", "nav.home", frontPageData},
+	"/adopt":               {"adopt.html", "nav.adopt", nil},
+	"/products":            {"products.html", "nav.products", productsPageData},
+	"/feedback/farmshop":   {"feedback-farmshop.html", "feedback.farmshop.title", nil},
+	"/feedback/experience": {"feedback-experience.html", "feedback.experience.title", nil},
+	"/feedback/thanks":     {"feedback-thanks.html", "feedback.thanks.title", nil},
+}
+
+// loadPageTemplates parses base.html with each page template
+func loadPageTemplates() error {
+	names := []string{"content-admin.html", "portal-login.html"}
+	for _, p := range pages {
+		names = append(names, p.template)
+	}
+	for _, name := range names {
+		t, err := template.New("").Funcs(templateFuncs).ParseFiles(
+			filepath.Join("templates", "base.html"), filepath.Join("templates", name))
+		if err != nil {
+			return err
+		}
+		pageTemplates[name] = t
+	}
+	return nil
+}
+
+// renderPage executes a page inside base.html. It renders into a buffer
+// first so a template error becomes a 500 instead of half a page.
+func renderPage(w http.ResponseWriter, name string, data map[string]interface{}) {
+	var buf bytes.Buffer
+	if err := pageTemplates[name].ExecuteTemplate(&buf, "base", data); err != nil {
+		log.Printf("Error rendering %s: %v", name, err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// withPages serves the template-rendered pages and hands every other path
+// to next, the static mirror of the old site
+func withPages(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := pages[r.URL.Path]
+		if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		locale := requestLocale(r)
+		data := map[string]interface{}{}
+		if p.data != nil {
+			var err error
+			if data, err = p.data(r, locale); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		data["Locale"] = locale
+		data["Path"] = r.URL.Path
+		data["Title"] = T(locale, p.title)
+		renderPage(w, p.template, data)
+	})
+}
+
+// frontPageData fills the front page with the CMS texts in the visitor's language
+func frontPageData(r *http.Request, locale string) (map[string]interface{}, error) {
+	content, err := loadSiteContent(locale)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"HeroTagline":       contentHTML(content["hero_tagline"]),
+		"HeroImage":         html.UnescapeString(content["hero_image"]),
+		"AboutText":         contentParagraphs(content["about_text"]),
+		"LightInDarkText":   contentParagraphs(content["light_in_dark_text"]),
+		"ExperienceNourish": contentHTML(content["experience_nourish"]),
+		"CtaText":           contentHTML(content["cta_text"]),
+	}, nil
+}
+
+// contentHTML marks a CMS text as HTML for the templates. The texts are
+// already sanitized when saved; doing it again covers rows saved before that.
+func contentHTML(value string) template.HTML {
+	clean, _ := sanitizeHTML(value)
+	return template.HTML(clean)
+}
+
+// contentParagraphs splits a CMS text into paragraphs at blank lines
+func contentParagraphs(value string) []template.HTML {
+	var paragraphs []template.HTML
+	for _, p := range strings.Split(value, "\n\n") {
+		paragraphs = append(paragraphs, contentHTML(p))
+	}
+	return paragraphs
+}
+
+// productCard is a shop product as the products page shows it
+type productCard struct {
+	Name        string
+	Description string
+	Price       float64
+	Image       string
+}
+
+// productsPageData lists the shop's products in the visitor's language. With
+// an empty shop the page keeps its built-in juice presentation.
+func productsPageData(r *http.Request, locale string) (map[string]interface{}, error) {
+	products, err := listProducts(false)
+	if err != nil {
+		return nil, err
+	}
+	var cards []productCard
+	for i := range products {
+		p := &products[i]
+		card := productCard{Name: p.NameIn(locale), Description: p.DescriptionIn(locale), Price: p.Price}
+		if len(p.Images) > 0 {
+			card.Image = p.Images[0]
+		}
+		cards = append(cards, card)
+	}
+	return map[string]interface{}{"Products": cards}, nil
+}
+
+// handleContentAdmin renders the CMS editor with the default texts
+func handleContentAdmin(w http.ResponseWriter, r *http.Request) {
+	content, err := loadSiteContent("")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	locale := requestLocale(r)
+	renderPage(w, "content-admin.html", map[string]interface{}{
+		"Locale":  locale,
+		"Path":    r.URL.Path,
+		"Title":   "Content Editor",
+		"Content": content,
+	})
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// portalCookie holds the adopter's session after they follow a login link
+const portalCookie = "ofvergards_portal"
+
+// PortalAdoption is one adopted tree as the adopter sees it
+type PortalAdoption struct {
+	ID         int64   `json:"id"`
+	Variety    string  `json:"variety"`
+	Years      int     `json:"years"`
+	StartDate  string  `json:"startDate"`
+	EndDate    string  `json:"endDate"`
+	Status     string  `json:"status"`
+	AmountPaid float64 `json:"amountPaid"`
+	IsGift     bool    `json:"isGift"`
+}
+
+// PortalBooking is a farm visit booked with the adopter's email
+type PortalBooking struct {
+	ID          int64   `json:"id"`
+	Activity    string  `json:"activity"`
+	StartTime   string  `json:"startTime"`
+	Quantity    int     `json:"quantity"`
+	Status      string  `json:"status"`
+	TotalAmount float64 `json:"totalAmount"`
+	TicketURL   string  `json:"ticketUrl,omitempty"`
+}
+
+// PortalReceipt is anything the adopter has paid for
+type PortalReceipt struct {
+	Kind        string  `json:"kind"` // adoption, visit, order
+	Description string  `json:"description"`
+	Amount      float64 `json:"amount"`
+	Date        string  `json:"date"`
+	URL         string  `json:"url,omitempty"`
+}
+
+func initPortalTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS portal_logins (
+		token TEXT PRIMARY KEY,
+		email TEXT,
+		new_email TEXT,
+		expires_at DATETIME,
+		used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS portal_sessions (
+		token TEXT PRIMARY KEY,
+		email TEXT,
+		expires_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating portal tables: %v", err)
+	}
+
+	// Migrations: contact phone and gift codes linked to the adoption that made them
+	db.Exec("ALTER TABLE customers ADD COLUMN phone TEXT")
+	if _, err := db.Exec("ALTER TABLE promocodes ADD COLUMN customer_id INTEGER"); err == nil {
+		// Gift codes were generated as GIFT-<customer id>-<n>
+		db.Exec(`UPDATE promocodes SET customer_id = CAST(substr(code, 6, instr(substr(code, 6), '-') - 1) AS INTEGER)
+			WHERE code LIKE 'GIFT-%-%' AND customer_id IS NULL`)
+	}
+}
+
+func portalLoginTTL() time.Duration   { return envMinutes("PORTAL_LOGIN_MINUTES", 30) }
+func portalSessionTTL() time.Duration { return envMinutes("PORTAL_SESSION_MINUTES", 60*24*30) }
+
+// normalizeEmail is how emails are compared, and how contacts store them
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// portalEmail returns the adopter signed in on this request, or "" if none
+func portalEmail(r *http.Request) string {
+	c, err := r.Cookie(portalCookie)
+	if err != nil || c.Value == "" {
+		return ""
+	}
+	var email string
+	if err := db.QueryRow("SELECT email FROM portal_sessions WHERE token = ? AND expires_at > ?", c.Value, dbTime(time.Now())).Scan(&email); err != nil {
+		return ""
+	}
+	return email
+}
+
+// sendPortalLink emails a one-time login link. With newEmail set the link
+// confirms a change of address instead and goes to the new address.
+func sendPortalLink(email, newEmail string) error {
+	token := newToken()
+	var pending interface{}
+	if newEmail != "" {
+		pending = newEmail
+	}
+	if _, err := db.Exec("INSERT INTO portal_logins (token, email, new_email, expires_at) VALUES (?, ?, ?, ?)",
+		token, email, pending, dbTime(time.Now().Add(portalLoginTTL()))); err != nil {
+		return err
+	}
+	data := map[string]interface{}{
+		"URL":     publicURL("/portal/login?token=" + token),
+		"Minutes": int(portalLoginTTL().Minutes()),
+	}
+	locale := preferredLocale(email, "")
+	if newEmail != "" {
+		sendCustomerEmail(newEmail, locale, "portal_email_change", data)
+		return nil
+	}
+	sendCustomerEmail(email, locale, "portal_login", data)
+	return nil
+}
+
+// handlePortalRequestLink starts a passwordless login: POST {"email"}. It
+// answers the same whether or not the email is known, so it can't be used to
+// find out who has adopted a tree.
+func handlePortalRequestLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	email := normalizeEmail(req.Email)
+
+	var known int
+	db.QueryRow("SELECT COUNT(*) FROM customers WHERE lower(trim(email)) = ?", email).Scan(&known)
+	if known > 0 {
+		if err := sendPortalLink(email, ""); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		log.Printf("🔑 Portal login link sent to %s", email)
+	} else {
+		log.Printf("🔑 Portal login requested for unknown email %s", email)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "If we have an adoption under that email, a login link is on its way.",
+	})
+}
+
+// handlePortalLoginPage is where the emailed link lands. It only shows a
+// button that posts the token back, because mail scanners and link previews
+// fetch the link too and would use it up before the adopter clicks.
+func handlePortalLoginPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	var newEmail sql.NullString
+	err := db.QueryRow("SELECT new_email FROM portal_logins WHERE token = ? AND used_at IS NULL AND expires_at > ?", token, dbTime(time.Now())).
+		Scan(&newEmail)
+	if err != nil {
+		http.Redirect(w, r, "/portal.html?expired=1", http.StatusFound)
+		return
+	}
+
+	locale := requestLocale(r)
+	w.Header().Set("Cache-Control", "no-store")
+	renderPage(w, "portal-login.html", map[string]interface{}{
+		"Locale":   locale,
+		"Path":     r.URL.Path,
+		"Title":    T(locale, "portal.login.title"),
+		"Token":    token,
+		"NewEmail": newEmail.String,
+	})
+}
+
+// handlePortalLogin spends the token posted from the login page, starts a
+// session cookie and sends the adopter on to the portal page
+func handlePortalLogin(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	now := dbTime(time.Now())
+
+	var email string
+	var newEmail sql.NullString
+	err := db.QueryRow("SELECT email, new_email FROM portal_logins WHERE token = ? AND used_at IS NULL AND expires_at > ?", token, now).
+		Scan(&email, &newEmail)
+	if err != nil {
+		http.Redirect(w, r, "/portal.html?expired=1", http.StatusFound)
+		return
+	}
+	if res, err := db.Exec("UPDATE portal_logins SET used_at = ? WHERE token = ? AND used_at IS NULL", now, token); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if n, _ := res.RowsAffected(); n == 0 {
+		http.Redirect(w, r, "/portal.html?expired=1", http.StatusFound)
+		return
+	}
+
+	if newEmail.Valid {
+		// Confirmed change of address: move adoptions and sign in under the new one
+		if _, err := db.Exec("UPDATE customers SET email = ? WHERE lower(trim(email)) = ?", newEmail.String, email); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err := moveContactEmail(email, newEmail.String); err != nil {
+			log.Printf("Error moving contact %s to %s: %v", email, newEmail.String, err)
+		}
+		db.Exec("DELETE FROM portal_sessions WHERE email = ?", email)
+		log.Printf("🔑 Adopter %s changed email to %s", email, newEmail.String)
+		email = newEmail.String
+	}
+
+	session := newToken()
+	expires := time.Now().Add(portalSessionTTL())
+	if _, err := db.Exec("INSERT INTO portal_sessions (token, email, expires_at) VALUES (?, ?, ?)", session, email, dbTime(expires)); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     portalCookie,
+		Value:    session,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(publicURL(""), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/portal.html", http.StatusFound)
+}
+
+// handlePortalLogout ends the session on this device
+func handlePortalLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(portalCookie); err == nil {
+		db.Exec("DELETE FROM portal_sessions WHERE token = ?", c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: portalCookie, Value: "", Path: "/", MaxAge: -1})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// handlePortal is the signed-in adopter's own data.
+// GET returns their adoptions, gift codes, bookings, receipts and newsletter
+// choice; PUT {"name", "phone", "country", "email", "newsletter", "language"}
+// updates contact details. A new email only takes effect once confirmed from
+// a link sent to that address. The language is what all their emails use.
+func handlePortal(w http.ResponseWriter, r *http.Request) {
+	email := portalEmail(r)
+	if email == "" {
+		writeError(w, http.StatusUnauthorized, "Not signed in")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		data, err := loadPortal(email)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+
+	case http.MethodPut:
+		var req struct {
+			Name       string `json:"name" validate:"required,max=200"`
+			Phone      string `json:"phone" validate:"max=40"`
+			Country    string `json:"country" validate:"max=100"`
+			Email      string `json:"email" validate:"email"`
+			Newsletter *bool  `json:"newsletter"`
+			Language   string `json:"language" validate:"locale"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if _, err := db.Exec("UPDATE customers SET name = ?, phone = ?, country = ? WHERE lower(trim(email)) = ?",
+			req.Name, strings.TrimSpace(req.Phone), strings.TrimSpace(req.Country), email); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if req.Language != "" {
+			db.Exec("UPDATE customers SET locale = ? WHERE lower(trim(email)) = ?", req.Language, email)
+		}
+		if req.Newsletter != nil {
+			if *req.Newsletter {
+				// Rejoining skips the welcome series they've already had
+				db.Exec("UPDATE customers SET newsletter_stage = 'monthly' WHERE lower(trim(email)) = ? AND newsletter_stage = 'none'", email)
+			} else {
+				db.Exec("UPDATE customers SET newsletter_stage = 'none' WHERE lower(trim(email)) = ?", email)
+			}
+		}
+
+		resp := map[string]interface{}{"success": true}
+		if newEmail := normalizeEmail(req.Email); newEmail != "" && newEmail != email {
+			if err := sendPortalLink(email, newEmail); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			resp["message"] = "We sent a confirmation link to " + newEmail
+		}
+		log.Printf("🔑 Adopter %s updated their details", email)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// loadPortal gathers everything the portal page shows for one email
+func loadPortal(email string) (map[string]interface{}, error) {
+	var name, phone, country, stage string
+	err := db.QueryRow(`SELECT name, COALESCE(phone, ''), COALESCE(country, ''), COALESCE(newsletter_stage, 'none')
+		FROM customers WHERE lower(trim(email)) = ? ORDER BY created_at DESC LIMIT 1`, email).Scan(&name, &phone, &country, &stage)
+	if err != nil {
+		return nil, err
+	}
+
+	adoptions := []PortalAdoption{}
+	var receipts []PortalReceipt
+	rows, err := db.Query(`SELECT id, tree_type, COALESCE(years, 1), created_at, status, COALESCE(amount_paid, 0), COALESCE(is_gift, 0)
+		FROM customers WHERE lower(trim(email)) = ? ORDER BY created_at ASC`, email)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var a PortalAdoption
+		var created string
+		if err := rows.Scan(&a.ID, &a.Variety, &a.Years, &created, &a.Status, &a.AmountPaid, &a.IsGift); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if start, err := parseSlotTime(created); err == nil {
+			a.StartDate = start.In(farmLocation).Format("2006-01-02")
+			a.EndDate = start.In(farmLocation).AddDate(a.Years, 0, 0).Format("2006-01-02")
+		}
+		adoptions = append(adoptions, a)
+		if a.Status != "interested" {
+			receipts = append(receipts, PortalReceipt{
+				Kind: "adoption", Description: fmt.Sprintf("%s tree adoption, %d year(s)", a.Variety, a.Years),
+				Amount: a.AmountPaid, Date: farmTime(created),
+			})
+		}
+	}
+	rows.Close()
+
+	type giftCode struct {
+		Code string `json:"code"`
+		Used bool   `json:"used"`
+	}
+	gifts := []giftCode{}
+	rows, err = db.Query(`SELECT p.code, p.is_used FROM promocodes p JOIN customers c ON p.customer_id = c.id
+		WHERE lower(trim(c.email)) = ? ORDER BY p.created_at`, email)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var g giftCode
+		if rows.Scan(&g.Code, &g.Used) == nil {
+			gifts = append(gifts, g)
+		}
+	}
+	rows.Close()
+
+	bookings := []PortalBooking{}
+	rows, err = db.Query(`SELECT b.id, s.activity, s.start_time, b.quantity, b.status, COALESCE(b.total_amount, 0), COALESCE(b.access_token, ''),
+		COALESCE(b.created_at, '')
+		FROM bookings b JOIN slots s ON b.slot_id = s.id
+		WHERE lower(trim(b.customer_email)) = ? AND b.status != 'expired' ORDER BY s.start_time DESC`, email)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var b PortalBooking
+		var slug, start, token, created string
+		if err := rows.Scan(&b.ID, &slug, &start, &b.Quantity, &b.Status, &b.TotalAmount, &token, &created); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		b.StartTime = farmTime(start)
+		b.Activity = slug
+		if token != "" && (b.Status == "paid" || b.Status == "confirmed") {
+			b.TicketURL = "/ticket.html?token=" + token
+		}
+		bookings = append(bookings, b)
+		if b.Status == "paid" || b.Status == "confirmed" || b.Status == "refunded" {
+			receipts = append(receipts, PortalReceipt{
+				Kind: "visit", Description: fmt.Sprintf("Visit booking #%d, %d person(s)", b.ID, b.Quantity),
+				Amount: b.TotalAmount, Date: farmTime(created),
+			})
+		}
+	}
+	rows.Close()
+	for i := range bookings {
+		bookings[i].Activity = activityName(bookings[i].Activity)
+	}
+
+	rows, err = db.Query(`SELECT id, total, COALESCE(paid_at, created_at), access_token FROM orders
+		WHERE lower(trim(customer_email)) = ? AND status NOT IN ('pending', 'expired', 'cancelled') ORDER BY created_at DESC`, email)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		var total float64
+		var paid, token string
+		if rows.Scan(&id, &total, &paid, &token) == nil {
+			receipts = append(receipts, PortalReceipt{
+				Kind: "order", Description: fmt.Sprintf("Farm shop order #%d", id),
+				Amount: total, Date: farmTime(paid), URL: "/shop.html?order=" + token,
+			})
+		}
+	}
+	rows.Close()
+	if receipts == nil {
+		receipts = []PortalReceipt{}
+	}
+	sort.SliceStable(receipts, func(i, j int) bool {
+		a, _ := time.Parse(time.RFC3339, receipts[i].Date)
+		b, _ := time.Parse(time.RFC3339, receipts[j].Date)
+		return a.After(b)
+	})
+
+	type harvestShare struct {
+		Season  int     `json:"season"`
+		Variety string  `json:"variety"`
+		Kg      float64 `json:"kg"`
+		Status  string  `json:"status"`
+		URL     string  `json:"url"`
+	}
+	harvests := []harvestShare{}
+	rows, err = db.Query(`SELECT h.season, h.variety, h.kg, h.status, h.access_token FROM harvest_shares h
+		JOIN customers c ON h.customer_id = c.id WHERE lower(trim(c.email)) = ? ORDER BY h.season DESC`, email)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var h harvestShare
+		var token string
+		if rows.Scan(&h.Season, &h.Variety, &h.Kg, &h.Status, &token) == nil {
+			h.URL = "/harvest.html?token=" + token
+			harvests = append(harvests, h)
+		}
+	}
+	rows.Close()
+
+	diary, err := diaryPostsFor(email, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"name":       name,
+		"email":      email,
+		"phone":      phone,
+		"country":    country,
+		"newsletter": stage != "none",
+		"language":   preferredLocale(email, ""),
+		"adoptions":  adoptions,
+		"giftCodes":  gifts,
+		"bookings":   bookings,
+		"receipts":   receipts,
+		"harvests":   harvests,
+		"diary":      diary,
+	}, nil
+}
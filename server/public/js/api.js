@@ -0,0 +1,41 @@
+// API errors come back as
+// {"success": false, "error": {"code": "not_found", "message": "Slot not found"}}.
+// apiError returns the message of a failed response for showing to people.
+async function apiError(res) {
+    try {
+        const body = await res.json();
+        if (body.error) return body.error.message;
+    } catch (e) {
+        // Not JSON, e.g. a proxy error page
+    }
+    return res.statusText || 'HTTP ' + res.status;
+}
+
+// adminFetch is fetch with the farm's admin token (ADMIN_TOKEN on the server),
+// which managing staff needs. It asks for the token once and remembers it.
+async function adminFetch(url, options = {}) {
+    const key = 'ofvergardsAdminToken';
+    for (let attempt = 0; attempt < 2; attempt++) {
+        let token = localStorage.getItem(key);
+        if (!token) {
+            token = prompt('Ange administratörsnyckeln:');
+            if (!token) throw new Error('Administratörsnyckel saknas');
+            localStorage.setItem(key, token.trim());
+        }
+        const headers = Object.assign({}, options.headers, { 'X-Admin-Token': token.trim() });
+        const res = await fetch(url, Object.assign({}, options, { headers }));
+        if (res.status !== 401) return res;
+        localStorage.removeItem(key);
+    }
+    throw new Error('Fel administratörsnyckel');
+}
+
+// formatFarmTime shows an API time ("2027-06-05T13:00:00+03:00") as the farm's
+// wall clock, using the offset in the string rather than a hardcoded zone, so
+// it follows FARM_TIMEZONE on the server. options are toLocaleString's.
+function formatFarmTime(iso, locale, options) {
+    const m = /([+-])(\d{2}):(\d{2})$/.exec(iso);
+    const offset = m ? (m[1] === '-' ? -1 : 1) * (Number(m[2]) * 60 + Number(m[3])) : 0;
+    const wallClock = new Date(new Date(iso).getTime() + offset * 60000);
+    return wallClock.toLocaleString(locale, Object.assign({}, options, { timeZone: 'UTC' }));
+}
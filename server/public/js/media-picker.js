@@ -0,0 +1,106 @@
+// Media library picker for the admin pages.
+// MediaPicker.open(media => ...) shows the library with an upload field and
+// calls back with the chosen image ({id, url, variants, alt, ...}).
+// MediaPicker.staffFetch(url, options) is fetch with the staff API key that
+// uploads and other changes need; it asks for the key when it's missing.
+// Load /js/api.js first.
+window.MediaPicker = (() => {
+    const TOKEN_KEY = 'ofvergardsStaffKey';
+    let onPick = null;
+
+    function askToken() {
+        const token = prompt('Ange din personalnyckel (API-nyckeln du fått av administratören):');
+        if (token) localStorage.setItem(TOKEN_KEY, token.trim());
+        return token;
+    }
+
+    async function staffFetch(url, options = {}) {
+        for (let attempt = 0; attempt < 2; attempt++) {
+            let token = localStorage.getItem(TOKEN_KEY);
+            if (!token && !(token = askToken())) throw new Error('Personalnyckel saknas');
+            const headers = Object.assign({}, options.headers, { 'X-Staff-Key': token });
+            const res = await fetch(url, Object.assign({}, options, { headers }));
+            if (res.status !== 401) return res;
+            localStorage.removeItem(TOKEN_KEY);
+        }
+        throw new Error('Fel personalnyckel');
+    }
+
+    function escapeHTML(s) {
+        const div = document.createElement('div');
+        div.innerText = s;
+        return div.innerHTML;
+    }
+
+    function ensureModal() {
+        if (document.getElementById('mediaPickerModal')) return;
+        document.body.insertAdjacentHTML('beforeend', `
+        <div id="mediaPickerModal" style="display:none; position:fixed; inset:0; background:rgba(0,0,0,0.5); z-index:9999; justify-content:center; align-items:center; font-family:system-ui, sans-serif;">
+            <div style="background:white; padding:20px; border-radius:8px; width:90%; max-width:760px; max-height:90vh; overflow-y:auto; position:relative;">
+                <button id="mediaPickerClose" style="position:absolute; top:10px; right:15px; background:none; border:none; font-size:24px; cursor:pointer;">&times;</button>
+                <h2 style="margin:0 0 12px; font-size:18px; font-weight:600; color:#333;">Bildbibliotek</h2>
+                <div style="display:flex; gap:8px; margin-bottom:12px; flex-wrap:wrap;">
+                    <input id="mediaPickerSearch" placeholder="Sök" style="flex:1; min-width:160px; padding:8px; border:1px solid #ddd; border-radius:4px;">
+                    <input id="mediaPickerAlt" placeholder="Alt-text för nya bilder" style="flex:1; min-width:160px; padding:8px; border:1px solid #ddd; border-radius:4px;">
+                    <label style="background:#4a6741; color:white; padding:8px 14px; border-radius:4px; cursor:pointer;">
+                        Ladda upp<input id="mediaPickerFile" type="file" multiple accept="image/jpeg,image/png,image/webp" style="display:none;">
+                    </label>
+                </div>
+                <p id="mediaPickerStatus" style="font-size:13px; color:#666; margin:0 0 8px;"></p>
+                <div id="mediaPickerGrid" style="display:grid; grid-template-columns:repeat(auto-fill, minmax(130px, 1fr)); gap:10px;"></div>
+            </div>
+        </div>`);
+
+        const modal = document.getElementById('mediaPickerModal');
+        document.getElementById('mediaPickerClose').onclick = () => modal.style.display = 'none';
+        modal.onclick = (e) => { if (e.target === modal) modal.style.display = 'none'; };
+
+        let searchTimer;
+        document.getElementById('mediaPickerSearch').oninput = () => {
+            clearTimeout(searchTimer);
+            searchTimer = setTimeout(load, 250);
+        };
+
+        document.getElementById('mediaPickerFile').onchange = async (e) => {
+            const status = document.getElementById('mediaPickerStatus');
+            const form = new FormData();
+            for (const file of e.target.files) form.append('file', file);
+            form.append('alt', document.getElementById('mediaPickerAlt').value);
+            status.innerText = 'Laddar upp...';
+            try {
+                const res = await staffFetch('/api/media', { method: 'POST', body: form });
+                status.innerText = res.ok ? '' : 'Fel: ' + await apiError(res);
+            } catch (err) {
+                status.innerText = err.message;
+            }
+            e.target.value = '';
+            load();
+        };
+    }
+
+    async function load() {
+        const q = document.getElementById('mediaPickerSearch').value;
+        const res = await fetch('/api/media' + (q ? '?q=' + encodeURIComponent(q) : ''));
+        const items = await res.json();
+        const grid = document.getElementById('mediaPickerGrid');
+        grid.innerHTML = items.map(m => `
+            <button type="button" data-id="${m.id}" title="${escapeHTML(m.originalName)}" style="border:1px solid #eee; border-radius:6px; padding:4px; background:white; cursor:pointer; text-align:left;">
+                <img src="${m.variants.thumb}" alt="${escapeHTML(m.alt)}" style="width:100%; height:100px; object-fit:cover; border-radius:4px;">
+                <div style="font-size:11px; color:#666; overflow:hidden; text-overflow:ellipsis; white-space:nowrap;">${escapeHTML(m.alt || m.originalName)}</div>
+            </button>`).join('') || '<p style="color:#999; font-style:italic;">Inga bilder ännu.</p>';
+        grid.querySelectorAll('button[data-id]').forEach(btn => btn.onclick = () => {
+            const media = items.find(m => m.id === parseInt(btn.dataset.id));
+            document.getElementById('mediaPickerModal').style.display = 'none';
+            if (onPick) onPick(media);
+        });
+    }
+
+    function open(callback) {
+        onPick = callback;
+        ensureModal();
+        document.getElementById('mediaPickerModal').style.display = 'flex';
+        load();
+    }
+
+    return { open, staffFetch };
+})();
@@ -1,7 +1,13 @@
 document.addEventListener('DOMContentLoaded', () => {
     const params = new URLSearchParams(window.location.search);
     const activity = params.get('activity');
-    if (activity) {
+    if (params.get('offer')) {
+        openWaitlistOffer(params.get('offer'));
+    } else if (params.get('private')) {
+        openPrivateSlot(params.get('private'));
+    } else if (params.get('cancel')) {
+        cancelBooking(params.get('cancel'));
+    } else if (activity) {
         selectActivity(activity);
     }
 });
@@ -92,8 +98,7 @@ function renderSlotsForDate(dateStr) {
 
     let html = '';
     slots.forEach(slot => {
-        const start = new Date(slot.startTime);
-        const timeStr = start.toLocaleTimeString('sv-SE', { hour: '2-digit', minute: '2-digit' });
+        const timeStr = formatFarmTime(slot.startTime, 'sv-SE', { hour: '2-digit', minute: '2-digit' });
         const available = slot.capacity - slot.booked;
 
         if (available > 0) {
@@ -107,6 +112,17 @@ function renderSlotsForDate(dateStr) {
                      <span class="inline-block px-2 py-0.5 bg-green-50 text-green-700 text-xs rounded-full">${available} left</span>
                 </div>
             </div>`;
+        } else {
+            html += `
+            <div class="slot-card p-3 rounded-lg flex justify-between items-center group bg-gray-50 border border-gray-100 cursor-pointer"
+                 onclick="showWaitlistForm(${slot.id}, '${displayDate} at ${timeStr}')">
+                <div>
+                    <span class="block font-bold text-gray-500">${timeStr}</span>
+                </div>
+                <div class="text-right">
+                     <span class="inline-block px-2 py-0.5 bg-gray-200 text-gray-600 text-xs rounded-full">Full · join waitlist</span>
+                </div>
+            </div>`;
         }
     });
     slotsList.innerHTML = html;
@@ -133,9 +149,99 @@ function selectSlot(id, timeText, maxQty) {
 function backToSlots() {
     document.getElementById('detailsForm').classList.add('hidden');
     document.getElementById('inquiryForm').classList.add('hidden');
+    document.getElementById('waitlistForm').classList.add('hidden');
     document.getElementById('step-slots').classList.remove('hidden');
 }
 
+function showWaitlistForm(slotId, timeText, partySize) {
+    document.getElementById('waitlistSlotId').value = slotId;
+    document.getElementById('waitlistTimeDisplay').innerText = timeText;
+    if (partySize) document.getElementById('waitlistParty').value = partySize;
+    // Carry over anything already typed into the booking form
+    document.getElementById('waitlistName').value = document.getElementById('visitName').value;
+    document.getElementById('waitlistEmail').value = document.getElementById('visitEmail').value;
+
+    document.getElementById('step-slots').classList.add('hidden');
+    document.getElementById('detailsForm').classList.add('hidden');
+    document.getElementById('waitlistForm').classList.remove('hidden');
+}
+
+// Emailed waitlist offers link here with ?offer=<token>; the seats are held
+// for this visitor until the offer expires
+async function openWaitlistOffer(token) {
+    document.getElementById('step-activity').classList.add('hidden');
+    try {
+        const res = await fetch(`/api/waitlist/offer?token=${encodeURIComponent(token)}`);
+        if (!res.ok) {
+            alert(res.status === 410 ? 'Sorry, this offer has expired and the seats went to the next person in line.' : 'Offer not found.');
+            window.location.href = '/book-visit.html';
+            return;
+        }
+        const offer = await res.json();
+        const dateStr = formatFarmTime(offer.slot.startTime, 'sv-SE', { weekday: 'long', month: 'long', day: 'numeric' });
+        const timeStr = formatFarmTime(offer.slot.startTime, 'sv-SE', { hour: '2-digit', minute: '2-digit' });
+        const expires = formatFarmTime(offer.entry.offerExpiresAt, 'sv-SE', { dateStyle: 'short', timeStyle: 'short' });
+
+        currentActivity = offer.slot.activity;
+        document.getElementById('pageTitle').innerText = 'Your Waitlist Offer';
+        document.getElementById('waitlistToken').value = token;
+        selectSlot(offer.slot.id, `${dateStr} at ${timeStr} (held until ${expires})`, offer.entry.partySize);
+        document.getElementById('visitQty').value = offer.entry.partySize;
+        document.getElementById('visitName').value = offer.entry.name;
+        document.getElementById('visitEmail').value = offer.entry.email;
+    } catch (err) {
+        console.error(err);
+        alert('Error connecting to server.');
+    }
+}
+
+// Accepted inquiries link here with ?private=<slot token>; the slot isn't
+// listed anywhere else
+async function openPrivateSlot(token) {
+    document.getElementById('step-activity').classList.add('hidden');
+    try {
+        const res = await fetch(`/api/slots/private?token=${encodeURIComponent(token)}`);
+        if (!res.ok) {
+            alert(res.status === 410 ? 'Sorry, this time has already passed.' : 'Booking link not found.');
+            window.location.href = '/book-visit.html';
+            return;
+        }
+        const data = await res.json();
+        const dateStr = formatFarmTime(data.slot.startTime, 'sv-SE', { weekday: 'long', month: 'long', day: 'numeric' });
+        const timeStr = formatFarmTime(data.slot.startTime, 'sv-SE', { hour: '2-digit', minute: '2-digit' });
+
+        currentActivity = data.slot.activity;
+        document.getElementById('pageTitle').innerText = 'Your Private Visit';
+        document.getElementById('slotToken').value = token;
+        selectSlot(data.slot.id, `${dateStr} at ${timeStr}`, data.slot.capacity - data.slot.booked);
+        document.getElementById('visitName').value = data.name;
+        document.getElementById('visitEmail').value = data.email;
+    } catch (err) {
+        console.error(err);
+        alert('Error connecting to server.');
+    }
+}
+
+// Confirmation emails link here with ?cancel=<booking token>
+async function cancelBooking(token) {
+    if (!confirm('Cancel your booking? Your seats will be offered to the next person on the waitlist.')) {
+        window.location.href = '/book-visit.html';
+        return;
+    }
+    try {
+        const res = await fetch('/api/bookings/cancel', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ token })
+        });
+        alert(res.ok ? 'Your booking has been cancelled.' : 'Could not cancel: ' + await apiError(res));
+    } catch (err) {
+        console.error(err);
+        alert('Error connecting to server.');
+    }
+    window.location.href = '/';
+}
+
 function showInquiryForm() {
     document.getElementById('step-slots').classList.add('hidden');
     document.getElementById('inquiryForm').classList.remove('hidden');
@@ -161,7 +267,9 @@ document.getElementById('detailsForm').addEventListener('submit', async (e) => {
         slotId: parseInt(document.getElementById('slotId').value),
         quantity: parseInt(document.getElementById('visitQty').value),
         customerName: document.getElementById('visitName').value,
-        customerEmail: document.getElementById('visitEmail').value
+        customerEmail: document.getElementById('visitEmail').value,
+        waitlistToken: document.getElementById('waitlistToken').value,
+        slotToken: document.getElementById('slotToken').value
     };
 
     try {
@@ -170,12 +278,62 @@ document.getElementById('detailsForm').addEventListener('submit', async (e) => {
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify(data)
         });
+        if (res.status === 409 && !data.waitlistToken && !data.slotToken) {
+            // Someone got there first, offer a place in the queue instead
+            btn.disabled = false;
+            btn.innerText = originalText;
+            showWaitlistForm(data.slotId, document.getElementById('selectedTimeDisplay').innerText, data.quantity);
+            return;
+        }
+        if (!res.ok) {
+            alert('Booking failed: ' + await apiError(res));
+            btn.disabled = false;
+            btn.innerText = originalText;
+            return;
+        }
         const result = await res.json();
 
         if (result.success) {
-            window.location.href = `/payment.html?id=${result.id}&name=${encodeURIComponent(result.name)}&tree=${encodeURIComponent(result.treeType)}&type=visit`;
+            window.location.href = `/payment.html?id=${result.id}&name=${encodeURIComponent(result.name)}&tree=${encodeURIComponent(result.treeType)}&amount=${result.amount}&type=visit&token=${result.accessToken}`;
+        } else {
+            alert('Booking failed: ' + (result.error ? result.error.message : 'Unknown error'));
+            btn.disabled = false;
+            btn.innerText = originalText;
+        }
+    } catch (err) {
+        console.error(err);
+        alert('Error connecting to server.');
+        btn.disabled = false;
+        btn.innerText = originalText;
+    }
+});
+
+document.getElementById('waitlistForm').addEventListener('submit', async (e) => {
+    e.preventDefault();
+    const btn = document.getElementById('joinWaitlistBtn');
+    const originalText = btn.innerText;
+    btn.disabled = true;
+    btn.innerText = 'Sending...';
+
+    const data = {
+        slotId: parseInt(document.getElementById('waitlistSlotId').value),
+        partySize: parseInt(document.getElementById('waitlistParty').value),
+        name: document.getElementById('waitlistName').value,
+        email: document.getElementById('waitlistEmail').value
+    };
+
+    try {
+        const res = await fetch('/api/waitlist', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(data)
+        });
+        if (res.ok) {
+            const result = await res.json();
+            alert(`You're number ${result.position} on the waitlist. We'll email you if seats free up.`);
+            window.location.href = '/';
         } else {
-            alert('Booking failed: ' + (result.message || 'Unknown error'));
+            alert('Could not join the waitlist: ' + await apiError(res));
             btn.disabled = false;
             btn.innerText = originalText;
         }
@@ -21,10 +21,20 @@ document.addEventListener('DOMContentLoaded', () => {
                 <form id="visitBookingForm">
                     <input type="hidden" id="slotId" name="slotId">
                     <div style="margin-bottom:10px;">
-                        <label style="display:block; margin-bottom:5px;">Antal personer (eller platser):</label>
-                        <input type="number" id="visitQty" name="quantity" min="1" max="10" value="1" style="width:100%; padding:8px; border:1px solid #ddd; border-radius:4px;" required>
+                        <label style="display:block; margin-bottom:5px;">Vuxna:</label>
+                        <input type="number" id="visitQty" name="quantity" min="0" max="10" value="1" style="width:100%; padding:8px; border:1px solid #ddd; border-radius:4px;" required>
                         <small id="qtyHelp" style="color:#666; font-size:12px;"></small>
                     </div>
+                    <div style="display:flex; gap:10px; margin-bottom:10px;">
+                        <div style="flex:1;">
+                            <label style="display:block; margin-bottom:5px;">Barn:</label>
+                            <input type="number" id="visitQtyChild" min="0" max="10" value="0" style="width:100%; padding:8px; border:1px solid #ddd; border-radius:4px;">
+                        </div>
+                        <div style="flex:1;">
+                            <label style="display:block; margin-bottom:5px;">Pensionärer:</label>
+                            <input type="number" id="visitQtySenior" min="0" max="10" value="0" style="width:100%; padding:8px; border:1px solid #ddd; border-radius:4px;">
+                        </div>
+                    </div>
                     <div style="margin-bottom:10px;">
                         <label style="display:block; margin-bottom:5px;">Namn:</label>
                         <input type="text" id="visitName" name="customerName" style="width:100%; padding:8px; border:1px solid #ddd; border-radius:4px;" required>
@@ -153,9 +163,8 @@ document.addEventListener('DOMContentLoaded', () => {
             } else {
                 let html = '';
                 slots.forEach(slot => {
-                    const start = new Date(slot.startTime);
-                    const dateStr = start.toLocaleDateString('sv-SE', { weekday: 'long', year: 'numeric', month: 'long', day: 'numeric' });
-                    const timeStr = start.toLocaleTimeString('sv-SE', { hour: '2-digit', minute: '2-digit' });
+                    const dateStr = formatFarmTime(slot.startTime, 'sv-SE', { weekday: 'long', year: 'numeric', month: 'long', day: 'numeric' });
+                    const timeStr = formatFarmTime(slot.startTime, 'sv-SE', { hour: '2-digit', minute: '2-digit' });
                     const available = slot.capacity - slot.booked;
 
                     if (available > 0) {
@@ -197,7 +206,11 @@ document.addEventListener('DOMContentLoaded', () => {
 
         const data = {
             slotId: parseInt(document.getElementById('slotId').value),
-            quantity: parseInt(document.getElementById('visitQty').value),
+            tickets: {
+                adult: parseInt(document.getElementById('visitQty').value) || 0,
+                child: parseInt(document.getElementById('visitQtyChild').value) || 0,
+                senior: parseInt(document.getElementById('visitQtySenior').value) || 0
+            },
             customerName: document.getElementById('visitName').value,
             customerEmail: document.getElementById('visitEmail').value
         };
@@ -212,9 +225,9 @@ document.addEventListener('DOMContentLoaded', () => {
 
             if (result.success) {
                 // Redirect to payment
-                window.location.href = `/payment.html?id=${result.id}&name=${encodeURIComponent(result.name)}&tree=${encodeURIComponent(result.treeType)}&type=visit`;
+                window.location.href = `/payment.html?id=${result.id}&name=${encodeURIComponent(result.name)}&tree=${encodeURIComponent(result.treeType)}&amount=${result.amount}&type=visit&token=${result.accessToken}`;
             } else {
-                alert('Bokning misslyckades: ' + (result.message || 'Okänt fel'));
+                alert('Bokning misslyckades: ' + (result.error ? result.error.message : 'Okänt fel'));
                 btn.disabled = false;
                 btn.innerText = 'Boka & Betala';
             }
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Resource is something a slot occupies exclusively: a guide, a tractor,
+// the picnic area
+type Resource struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name" validate:"required,max=200"`
+	Kind      string `json:"kind" validate:"oneof=guide|vehicle|area"`
+	Active    bool   `json:"active"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// SlotConflict describes why a slot could not be created at StartTime
+type SlotConflict struct {
+	StartTime      string `json:"startTime"`
+	ResourceID     int64  `json:"resourceId,omitempty"`
+	ResourceName   string `json:"resourceName,omitempty"`
+	ConflictSlotID int64  `json:"conflictSlotId,omitempty"`
+	Message        string `json:"message"`
+}
+
+func initResourceTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS resources (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT,
+		kind TEXT,
+		active BOOLEAN DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS activity_resources (
+		activity_id INTEGER,
+		resource_id INTEGER,
+		PRIMARY KEY (activity_id, resource_id),
+		FOREIGN KEY(activity_id) REFERENCES activities(id),
+		FOREIGN KEY(resource_id) REFERENCES resources(id)
+	);
+	CREATE TABLE IF NOT EXISTS slot_resources (
+		slot_id INTEGER,
+		resource_id INTEGER,
+		PRIMARY KEY (slot_id, resource_id),
+		FOREIGN KEY(slot_id) REFERENCES slots(id),
+		FOREIGN KEY(resource_id) REFERENCES resources(id)
+	);
+	`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating resource tables: %v", err)
+	}
+}
+
+// activityResourceIDs returns the resources an activity needs by default
+func activityResourceIDs(q queryer, activityID int64) ([]int64, error) {
+	rows, err := q.Query("SELECT resource_id FROM activity_resources WHERE activity_id = ? ORDER BY resource_id", activityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// findSlotConflicts returns the resources in resourceIDs that are already
+// assigned to a slot overlapping [start, end). excludeSlotID lets a slot being
+// moved ignore itself.
+func findSlotConflicts(q queryer, start, end time.Time, resourceIDs []int64, excludeSlotID int64) ([]SlotConflict, error) {
+	var conflicts []SlotConflict
+	for _, rid := range resourceIDs {
+		rows, err := q.Query(`
+			SELECT s.id, s.activity, s.start_time, r.name
+			FROM slot_resources sr
+			JOIN slots s ON sr.slot_id = s.id
+			JOIN resources r ON sr.resource_id = r.id
+			WHERE sr.resource_id = ? AND s.start_time < ? AND s.end_time > ? AND s.id != ?`,
+			rid, dbTime(end), dbTime(start), excludeSlotID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var slotID int64
+			var activity, otherStart, resourceName string
+			if err := rows.Scan(&slotID, &activity, &otherStart, &resourceName); err != nil {
+				continue
+			}
+			conflicts = append(conflicts, SlotConflict{
+				StartTime:      start.In(farmLocation).Format(time.RFC3339),
+				ResourceID:     rid,
+				ResourceName:   resourceName,
+				ConflictSlotID: slotID,
+				Message:        fmt.Sprintf("%s is already booked for %s (slot #%d at %s)", resourceName, activity, slotID, farmTime(otherStart)),
+			})
+		}
+		rows.Close()
+	}
+	return conflicts, nil
+}
+
+// conflictMessage describes conflicts for the error message, which can be
+// an overlap or a date outside the activity's season
+func conflictMessage(conflicts []SlotConflict) string {
+	msg := conflicts[0].Message
+	if more := len(conflicts) - 1; more > 0 {
+		msg += fmt.Sprintf(" (and %d more)", more)
+	}
+	return msg
+}
+
+// slotOrigin records where a slot came from. The zero value is a one-off
+// public slot.
+type slotOrigin struct {
+	SeriesID     int64  // generated from a slot series
+	InquiryID    int64  // private slot created by accepting an inquiry
+	PrivateToken string // required to see or book a private slot
+}
+
+// createSlot inserts one slot and its resource assignments after checking for
+// overlaps. Conflicts are returned instead of an error so callers can report
+// them; err is only set for database failures.
+func createSlot(activity *Activity, start time.Time, duration time.Duration, capacity int, resourceIDs []int64, origin slotOrigin) (int64, []SlotConflict, error) {
+	end := start.Add(duration)
+
+	if !activity.InSeason(start) {
+		return 0, []SlotConflict{{
+			StartTime: start.In(farmLocation).Format(time.RFC3339),
+			Message:   fmt.Sprintf("%s is outside the %s season (%s to %s)", start.In(farmLocation).Format("2006-01-02"), activity.Name, activity.SeasonStart, activity.SeasonEnd),
+		}}, nil
+	}
+
+	// Check and insert in one transaction so two admins can't double-book a guide
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	conflicts, err := findSlotConflicts(tx, start, end, resourceIDs, 0)
+	if err != nil {
+		tx.Rollback()
+		return 0, nil, err
+	}
+	if len(conflicts) > 0 {
+		tx.Rollback()
+		return 0, conflicts, nil
+	}
+
+	series := sql.NullInt64{Int64: origin.SeriesID, Valid: origin.SeriesID != 0}
+	inquiry := sql.NullInt64{Int64: origin.InquiryID, Valid: origin.InquiryID != 0}
+	token := sql.NullString{String: origin.PrivateToken, Valid: origin.PrivateToken != ""}
+	res, err := tx.Exec("INSERT INTO slots (activity, start_time, end_time, capacity, series_id, inquiry_id, private_token) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		activity.Slug, dbTime(start), dbTime(end), capacity, series, inquiry, token)
+	if err != nil {
+		tx.Rollback()
+		return 0, nil, err
+	}
+	slotID, _ := res.LastInsertId()
+
+	for _, rid := range resourceIDs {
+		if _, err := tx.Exec("INSERT INTO slot_resources (slot_id, resource_id) VALUES (?, ?)", slotID, rid); err != nil {
+			tx.Rollback()
+			return 0, nil, err
+		}
+	}
+
+	return slotID, nil, tx.Commit()
+}
+
+// handleResources manages guides, vehicles and areas.
+// GET lists them, POST creates one and DELETE ?id= deactivates it.
+func handleResources(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query("SELECT id, name, kind, active, created_at FROM resources ORDER BY kind, name")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer rows.Close()
+
+		var resources []Resource
+		for rows.Next() {
+			var res Resource
+			if err := rows.Scan(&res.ID, &res.Name, &res.Kind, &res.Active, &res.CreatedAt); err != nil {
+				continue
+			}
+			resources = append(resources, res)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resources)
+
+	case http.MethodPost:
+		var res Resource
+		if !decodeJSON(w, r, &res) {
+			return
+		}
+		if res.Kind == "" {
+			res.Kind = "guide"
+		}
+		result, err := db.Exec("INSERT INTO resources (name, kind) VALUES (?, ?)", res.Name, res.Kind)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		res.ID, _ = result.LastInsertId()
+		res.Active = true
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "resource": res})
+
+	case http.MethodDelete:
+		id, _ := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+		result, err := db.Exec("UPDATE resources SET active = 0 WHERE id = ?", id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			writeError(w, http.StatusNotFound, "Resource not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// validResourceIDs checks that every id refers to an active resource
+func validResourceIDs(q queryer, ids []int64) error {
+	for _, id := range ids {
+		var active bool
+		err := q.QueryRow("SELECT active FROM resources WHERE id = ?", id).Scan(&active)
+		if err == sql.ErrNoRows || (err == nil && !active) {
+			return fmt.Errorf("resource %d does not exist or is inactive", id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// Every route is registered with its method on one ServeMux ("GET
+// /api/slots/{id}"), so handlers no longer check r.Method themselves. The
+// mux is wrapped in a shared middleware chain, and API errors all use the
+// same JSON envelope:
+//
+//	{"success": false, "error": {"code": "not_found", "message": "Slot not found"}}
+//
+// The code is stable for the admin JS to branch on; the message is for people.
+
+// middleware wraps a handler, e.g. withLocale or withSecurityHeaders
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h in the middlewares, the first one outermost
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// errorCodes are the default codes per status; handlers pass their own code
+// to writeErrorDetails when the admin JS needs to tell cases apart
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusInternalServerError:   "internal",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError sends the JSON error envelope with the status's default code
+func writeError(w http.ResponseWriter, status int, message string) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+// writeErrorDetails sends the JSON error envelope with a specific code and
+// extra top-level fields, e.g. the conflicting slots
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details map[string]interface{}) {
+	body := map[string]interface{}{}
+	for k, v := range details {
+		body[k] = v
+	}
+	body["success"] = false
+	body["error"] = apiError{Code: code, Message: strings.TrimSpace(message)}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// pathParam reads a {name} path segment, falling back to the ?name= query
+// parameter the older URLs use
+func pathParam(r *http.Request, name string) string {
+	if v := r.PathValue(name); v != "" {
+		return v
+	}
+	return r.URL.Query().Get(name)
+}
+
+// withRecover turns a panicking handler into a 500 instead of a dropped
+// connection
+func withRecover(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Printf("💥 Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+				writeError(w, http.StatusInternalServerError, "Internal server error")
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// apiFallback answers /api/ requests no route matched: 405 with an Allow
+// header when the path exists for other methods, otherwise 404. Without it
+// they would reach the static site and get a plain-text error.
+func apiFallback(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			if _, pattern := mux.Handler(probe); pattern != "" && pattern != "/api/" {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) == 0 {
+			writeError(w, http.StatusNotFound, "No such endpoint: "+r.URL.Path)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+	}
+}
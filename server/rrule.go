@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RRule is the subset of RFC 5545 recurrence rules used for slot series:
+// FREQ=DAILY|WEEKLY|MONTHLY with INTERVAL, COUNT, UNTIL, BYDAY (plain weekdays)
+// and BYMONTHDAY. Weeks start on Monday.
+type RRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []time.Weekday
+	ByMonthDay []int
+}
+
+const (
+	// maxSeriesOccurrences caps how many slots one series can generate
+	maxSeriesOccurrences = 500
+	// seriesHorizon bounds open-ended rules without COUNT or UNTIL
+	seriesHorizon = 2 * 365 * 24 * time.Hour
+)
+
+var rruleWeekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// parseRRule parses a rule such as "FREQ=WEEKLY;BYDAY=SA,SU;UNTIL=20270930T000000Z".
+// A leading "RRULE:" is accepted.
+func parseRRule(s string) (*RRule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, fmt.Errorf("empty rule")
+	}
+
+	r := &RRule{Interval: 1}
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+
+		switch key {
+		case "FREQ":
+			if value != "DAILY" && value != "WEEKLY" && value != "MONTHLY" {
+				return nil, fmt.Errorf("unsupported FREQ %q, use DAILY, WEEKLY or MONTHLY", value)
+			}
+			r.Freq = value
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid INTERVAL %q", value)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid COUNT %q", value)
+			}
+			r.Count = n
+		case "UNTIL":
+			t, err := parseRRuleTime(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid UNTIL %q", value)
+			}
+			r.Until = t
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				wd, ok := rruleWeekdays[d]
+				if !ok {
+					return nil, fmt.Errorf("unsupported BYDAY value %q", d)
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(d)
+				if err != nil || n < 1 || n > 31 {
+					return nil, fmt.Errorf("unsupported BYMONTHDAY value %q", d)
+				}
+				r.ByMonthDay = append(r.ByMonthDay, n)
+			}
+		case "WKST":
+			if value != "MO" {
+				return nil, fmt.Errorf("only WKST=MO is supported")
+			}
+		default:
+			return nil, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+
+	if r.Freq == "" {
+		return nil, fmt.Errorf("FREQ is required")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, fmt.Errorf("COUNT and UNTIL cannot both be set")
+	}
+	if r.Freq == "MONTHLY" && len(r.ByDay) > 0 {
+		return nil, fmt.Errorf("BYDAY is not supported with FREQ=MONTHLY")
+	}
+	return r, nil
+}
+
+// parseRRuleTime accepts the UNTIL forms YYYYMMDD, YYYYMMDDTHHMMSS and YYYYMMDDTHHMMSSZ
+func parseRRuleTime(s string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			if layout == "20060102" {
+				// A date-only UNTIL includes the whole day
+				t = t.Add(24*time.Hour - time.Second)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// String renders the rule back into RFC 5545 form
+func (r *RRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	if len(r.ByDay) > 0 {
+		var days []string
+		for _, wd := range r.ByDay {
+			for k, v := range rruleWeekdays {
+				if v == wd {
+					days = append(days, k)
+				}
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		var days []string
+		for _, d := range r.ByMonthDay {
+			days = append(days, strconv.Itoa(d))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Expand returns the occurrence start times from dtstart, keeping dtstart's
+// time of day and location. COUNT is applied before exdates are removed, as
+// RFC 5545 specifies. Exdates match either a whole day ("2027-06-25") or an
+// exact start time (RFC3339).
+func (r *RRule) Expand(dtstart time.Time, exdates []string) []time.Time {
+	until := r.Until
+	if until.IsZero() {
+		until = dtstart.Add(seriesHorizon)
+	}
+
+	var candidates []time.Time
+	emit := func(t time.Time) bool {
+		if t.Before(dtstart) {
+			return true
+		}
+		if t.After(until) || (r.Count > 0 && len(candidates) >= r.Count) || len(candidates) >= maxSeriesOccurrences {
+			return false
+		}
+		candidates = append(candidates, t)
+		return true
+	}
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, dtstart.Hour(), dtstart.Minute(), dtstart.Second(), 0, dtstart.Location())
+	}
+
+	switch r.Freq {
+	case "DAILY":
+		allowed := map[time.Weekday]bool{}
+		for _, wd := range r.ByDay {
+			allowed[wd] = true
+		}
+		for t := dtstart; ; t = t.AddDate(0, 0, r.Interval) {
+			if len(allowed) > 0 && !allowed[t.Weekday()] {
+				if t.After(until) {
+					break
+				}
+				continue
+			}
+			if !emit(t) {
+				break
+			}
+		}
+
+	case "WEEKLY":
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{dtstart.Weekday()}
+		}
+		// Order weekdays Monday first to match WKST=MO
+		offsets := make([]int, 0, len(days))
+		for _, wd := range days {
+			offsets = append(offsets, (int(wd)+6)%7)
+		}
+		sort.Ints(offsets)
+
+		weekStart := at(dtstart.Year(), dtstart.Month(), dtstart.Day()-(int(dtstart.Weekday())+6)%7)
+	weeks:
+		for ; ; weekStart = weekStart.AddDate(0, 0, 7*r.Interval) {
+			for _, off := range offsets {
+				if !emit(weekStart.AddDate(0, 0, off)) {
+					break weeks
+				}
+			}
+		}
+
+	case "MONTHLY":
+		monthDays := r.ByMonthDay
+		if len(monthDays) == 0 {
+			monthDays = []int{dtstart.Day()}
+		}
+		sort.Ints(monthDays)
+	months:
+		for i := 0; ; i += r.Interval {
+			first := time.Date(dtstart.Year(), dtstart.Month()+time.Month(i), 1, 0, 0, 0, 0, dtstart.Location())
+			for _, d := range monthDays {
+				t := at(first.Year(), first.Month(), d)
+				if t.Month() != first.Month() {
+					continue // e.g. the 31st in a 30-day month is skipped
+				}
+				if !emit(t) {
+					break months
+				}
+			}
+			if first.After(until) {
+				break
+			}
+		}
+	}
+
+	excludedDays := map[string]bool{}
+	excludedTimes := map[int64]bool{}
+	for _, ex := range exdates {
+		if t, err := time.Parse(time.RFC3339, ex); err == nil {
+			excludedTimes[t.Unix()] = true
+		} else {
+			excludedDays[ex] = true
+		}
+	}
+
+	var out []time.Time
+	for _, t := range candidates {
+		if excludedDays[t.Format("2006-01-02")] || excludedTimes[t.Unix()] {
+			continue
+		}
+		out = append(out, t)
+	}
+	return out
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// sanitizeAllowed is the HTML that staff-written content may contain: what
+// the newsletter editor (Quill) produces plus a few common text elements.
+// Each tag maps to the attributes it may keep.
+var sanitizeAllowed = map[string]map[string]bool{
+	"p": {"class": true}, "br": {}, "hr": {}, "div": {"class": true}, "span": {"class": true},
+	"h1": {"class": true}, "h2": {"class": true}, "h3": {"class": true}, "h4": {"class": true},
+	"strong": {}, "b": {}, "em": {}, "i": {}, "u": {}, "s": {}, "sub": {}, "sup": {},
+	"ul": {"class": true}, "ol": {"class": true}, "li": {"class": true},
+	"blockquote": {}, "pre": {"class": true}, "code": {},
+	"a":     {"href": true, "title": true, "target": true, "rel": true},
+	"img":   {"src": true, "alt": true, "title": true, "width": true, "height": true},
+	"table": {}, "thead": {}, "tbody": {}, "tr": {}, "th": {"colspan": true, "rowspan": true}, "td": {"colspan": true, "rowspan": true},
+}
+
+// sanitizeDropContent are removed together with everything inside them.
+// Other tags that aren't allowed are removed but keep their text.
+var sanitizeDropContent = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "noscript": true,
+	"template": true, "svg": true, "math": true, "form": true, "applet": true,
+	"head": true, "title": true, "textarea": true, "select": true,
+}
+
+// Only Quill's own formatting classes are kept
+var sanitizeClassPattern = regexp.MustCompile(`^ql-[a-z0-9-]+$`)
+
+var sanitizeSizePattern = regexp.MustCompile(`^[0-9]{1,4}%?$`)
+
+// safeURL reports whether a link or image source may be kept: relative
+// URLs, http(s), and for links mailto: and tel:
+func safeURL(raw string, isLink bool) bool {
+	u := strings.ToLower(strings.TrimSpace(raw))
+	// Browsers ignore whitespace and control characters inside schemes
+	u = strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, u)
+	if strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") {
+		return true
+	}
+	if isLink && (strings.HasPrefix(u, "mailto:") || strings.HasPrefix(u, "tel:")) {
+		return true
+	}
+	if strings.HasPrefix(u, "//") {
+		return false
+	}
+	// Relative: no scheme before the first /, ? or #
+	colon := strings.IndexByte(u, ':')
+	return colon == -1 || strings.IndexAny(u[:colon], "/?#") != -1
+}
+
+// sanitizeTextEscaper escapes text between tags. Quotes only need escaping
+// in attributes, and leaving them keeps "farm's" readable in the editors.
+var sanitizeTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// sanitizeHTML rewrites staff-written HTML so only allowlisted tags,
+// attributes and URLs remain. It returns the clean HTML and a description
+// of everything it removed (empty when the input was already clean).
+func sanitizeHTML(input string) (string, []string) {
+	var out strings.Builder
+	stripped := map[string]bool{}
+	z := html.NewTokenizer(strings.NewReader(input))
+	drop := "" // the element whose content is being skipped
+	depth := 0
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break // io.EOF; the tokenizer reads from a string
+		}
+		tok := z.Token()
+		name := tok.Data
+
+		if drop != "" {
+			if tok.Type == html.StartTagToken && name == drop {
+				depth++
+			} else if tok.Type == html.EndTagToken && name == drop {
+				if depth--; depth == 0 {
+					drop = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			out.WriteString(sanitizeTextEscaper.Replace(tok.Data))
+
+		case html.CommentToken:
+			stripped["comments"] = true
+
+		case html.DoctypeToken:
+			stripped["<!DOCTYPE>"] = true
+
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			if sanitizeDropContent[name] {
+				stripped["<"+name+">"] = true
+				if tt == html.StartTagToken {
+					drop, depth = name, 1
+				}
+				continue
+			}
+			allowed, ok := sanitizeAllowed[name]
+			if !ok {
+				// Unknown wrappers like <font> go, their text stays
+				stripped["<"+name+">"] = true
+				continue
+			}
+			if tt == html.EndTagToken {
+				if name != "br" && name != "img" && name != "hr" {
+					out.WriteString("</" + name + ">")
+				}
+				continue
+			}
+
+			var attrs []html.Attribute
+			for _, a := range tok.Attr {
+				key := strings.ToLower(a.Key)
+				keep := allowed[key] && a.Namespace == ""
+				switch {
+				case !keep:
+				case key == "href":
+					keep = safeURL(a.Val, true)
+				case key == "src":
+					keep = safeURL(a.Val, false)
+				case key == "class":
+					var classes []string
+					for _, c := range strings.Fields(a.Val) {
+						if sanitizeClassPattern.MatchString(c) {
+							classes = append(classes, c)
+						} else {
+							stripped[fmt.Sprintf("class %q on <%s>", c, name)] = true
+						}
+					}
+					if len(classes) > 0 {
+						attrs = append(attrs, html.Attribute{Key: key, Val: strings.Join(classes, " ")})
+					}
+					continue
+				case key == "target":
+					keep = a.Val == "_blank"
+				case key == "width" || key == "height":
+					keep = sanitizeSizePattern.MatchString(a.Val)
+				}
+				if !keep {
+					stripped[fmt.Sprintf("%s=%q on <%s>", key, shorten(a.Val, 40), name)] = true
+					continue
+				}
+				attrs = append(attrs, html.Attribute{Key: key, Val: a.Val})
+			}
+			if name == "a" {
+				attrs = linkRel(attrs)
+			}
+			if name == "img" && !hasAttr(attrs, "src") {
+				continue
+			}
+
+			out.WriteString("<" + name)
+			for _, a := range attrs {
+				fmt.Fprintf(&out, " %s=\"%s\"", a.Key, html.EscapeString(a.Val))
+			}
+			out.WriteString(">")
+		}
+	}
+
+	report := make([]string, 0, len(stripped))
+	for s := range stripped {
+		report = append(report, s)
+	}
+	sort.Strings(report)
+	return out.String(), report
+}
+
+// linkRel makes links that open a new tab unable to reach back into the page
+func linkRel(attrs []html.Attribute) []html.Attribute {
+	if !hasAttr(attrs, "target") {
+		return attrs
+	}
+	for i, a := range attrs {
+		if a.Key == "rel" {
+			attrs[i].Val = "noopener noreferrer"
+			return attrs
+		}
+	}
+	return append(attrs, html.Attribute{Key: "rel", Val: "noopener noreferrer"})
+}
+
+func hasAttr(attrs []html.Attribute, key string) bool {
+	for _, a := range attrs {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func shorten(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n]) + "…"
+	}
+	return s
+}
+
+// strippedError describes what the sanitizer removed, for the admin pages
+func strippedError(field string, stripped []string) string {
+	return fmt.Sprintf("%s contains HTML that isn't allowed and was not saved. Remove: %s", field, strings.Join(stripped, ", "))
+}
+
+// contentSecurityPolicy limits where public pages load code from. The
+// mirrored WordPress pages rely on inline scripts, so those stay allowed;
+// the policy blocks plugins, foreign <base> tags, framing and scripts from
+// hosts the site doesn't use.
+const contentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'unsafe-inline' https://cdn.tailwindcss.com https://cdnjs.cloudflare.com https://cdn.jsdelivr.net https://npmcdn.com https://c0.wp.com https://stats.wp.com https://www.googletagmanager.com; " +
+	"style-src 'self' 'unsafe-inline' https://cdnjs.cloudflare.com https://cdn.jsdelivr.net https://c0.wp.com https://fonts.googleapis.com; " +
+	"font-src 'self' data: https://cdnjs.cloudflare.com https://fonts.gstatic.com https://c0.wp.com; " +
+	"img-src 'self' data: https:; " +
+	"connect-src 'self' https://stats.wp.com https://www.google-analytics.com https://*.google-analytics.com https://www.googletagmanager.com; " +
+	"frame-src 'self' https://www.google.com https://maps.google.com https://www.youtube.com; " +
+	"object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'self'"
+
+// withSecurityHeaders adds the Content-Security-Policy and related headers
+// to public pages
+func withSecurityHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.ServeHTTP(w, r)
+	})
+}
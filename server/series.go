@@ -0,0 +1,440 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SlotSeries is a recurring set of slots generated from an RFC 5545 RRULE
+type SlotSeries struct {
+	ID              int64    `json:"id"`
+	Activity        string   `json:"activity"`
+	StartTime       string   `json:"startTime"` // DTSTART, RFC3339
+	DurationMinutes int      `json:"durationMinutes"`
+	Capacity        int      `json:"capacity"`
+	RRule           string   `json:"rrule"`
+	ExDates         []string `json:"exdates"` // YYYY-MM-DD or RFC3339
+	ResourceIDs     []int64  `json:"resourceIds"`
+	CreatedAt       string   `json:"createdAt"`
+	Occurrences     []Slot   `json:"occurrences,omitempty"`
+}
+
+// SlotProtected reports an occurrence left untouched because it has bookings
+type SlotProtected struct {
+	SlotID int64  `json:"slotId"`
+	Reason string `json:"reason"`
+}
+
+func initSeriesTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS slot_series (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		activity TEXT,
+		start_time DATETIME,
+		duration_minutes INTEGER,
+		capacity INTEGER,
+		rrule TEXT,
+		exdates TEXT DEFAULT '[]',
+		resource_ids TEXT DEFAULT '[]',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating series tables: %v", err)
+	}
+
+	// Migration: link generated occurrences to their series
+	db.Exec("ALTER TABLE slots ADD COLUMN series_id INTEGER REFERENCES slot_series(id)")
+}
+
+// legacyRecurrenceRule translates the old recurWeeks/recurDays form fields
+// into an RRULE so both paths produce a series
+func legacyRecurrenceRule(start time.Time, weeks int, days []int) string {
+	if weeks < 1 {
+		weeks = 1
+	}
+	if len(days) == 0 {
+		return fmt.Sprintf("FREQ=WEEKLY;COUNT=%d", weeks)
+	}
+	rule := &RRule{Freq: "WEEKLY", Interval: 1, Until: start.AddDate(0, 0, weeks*7).Add(-time.Second)}
+	for _, d := range days {
+		rule.ByDay = append(rule.ByDay, time.Weekday(d))
+	}
+	return rule.String()
+}
+
+// createSeries stores a series and generates its occurrences. Occurrences
+// that clash with resources or the season are returned as conflicts.
+func createSeries(activity *Activity, start time.Time, duration time.Duration, capacity int, rule *RRule, exdates []string, resourceIDs []int64) (int64, []int64, []SlotConflict, error) {
+	if exdates == nil {
+		exdates = []string{}
+	}
+	if resourceIDs == nil {
+		resourceIDs = []int64{}
+	}
+	exJSON, _ := json.Marshal(exdates)
+	resJSON, _ := json.Marshal(resourceIDs)
+
+	res, err := db.Exec(`INSERT INTO slot_series (activity, start_time, duration_minutes, capacity, rrule, exdates, resource_ids)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		activity.Slug, dbTime(start), int(duration/time.Minute), capacity, rule.String(), string(exJSON), string(resJSON))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	seriesID, _ := res.LastInsertId()
+
+	created := []int64{}
+	conflicts := []SlotConflict{}
+	// Expand in farm time so occurrences keep their wall-clock time over DST
+	for _, t := range rule.Expand(start.In(farmLocation), exdates) {
+		id, c, err := createSlot(activity, t, duration, capacity, resourceIDs, slotOrigin{SeriesID: seriesID})
+		if err != nil {
+			return seriesID, created, conflicts, err
+		}
+		if len(c) > 0 {
+			conflicts = append(conflicts, c...)
+			continue
+		}
+		created = append(created, id)
+	}
+	return seriesID, created, conflicts, nil
+}
+
+func getSeries(id int64) (*SlotSeries, error) {
+	var s SlotSeries
+	var exdates, resourceIDs string
+	err := db.QueryRow(`SELECT id, activity, start_time, duration_minutes, capacity, rrule, exdates, resource_ids, created_at
+		FROM slot_series WHERE id = ?`, id).Scan(&s.ID, &s.Activity, &s.StartTime, &s.DurationMinutes, &s.Capacity, &s.RRule, &exdates, &resourceIDs, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(exdates), &s.ExDates)
+	json.Unmarshal([]byte(resourceIDs), &s.ResourceIDs)
+	return &s, nil
+}
+
+// seriesOccurrences returns the series' slots starting at or after from
+func seriesOccurrences(seriesID int64, from time.Time) ([]Slot, error) {
+	rows, err := db.Query(`SELECT id, activity, start_time, end_time, capacity, booked, series_id FROM slots
+		WHERE series_id = ? AND start_time >= ? ORDER BY start_time ASC`, seriesID, dbTime(from))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slots []Slot
+	for rows.Next() {
+		var s Slot
+		if err := rows.Scan(&s.ID, &s.Activity, &s.StartTime, &s.EndTime, &s.Capacity, &s.Booked, &s.SeriesID); err != nil {
+			continue
+		}
+		s.localize()
+		slots = append(slots, s)
+	}
+	return slots, nil
+}
+
+// slotHasBookings reports whether anyone has booked the slot
+func slotHasBookings(slotID int64) bool {
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM bookings WHERE slot_id = ?", slotID).Scan(&n)
+	return n > 0
+}
+
+// scopedOccurrences picks the occurrences an edit or cancel applies to:
+// "this" is the given slot, "following" is that slot and every later one,
+// and "all" is every occurrence that hasn't started yet.
+func scopedOccurrences(series *SlotSeries, scope string, slotID int64) ([]Slot, *Slot, error) {
+	if scope == "all" {
+		slots, err := seriesOccurrences(series.ID, time.Now().UTC())
+		return slots, nil, err
+	}
+	if scope != "this" && scope != "following" {
+		return nil, nil, fmt.Errorf("scope must be this, following or all")
+	}
+
+	all, err := seriesOccurrences(series.ID, time.Time{})
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, s := range all {
+		if s.ID != slotID {
+			continue
+		}
+		if scope == "this" {
+			return all[i : i+1], &all[i], nil
+		}
+		return all[i:], &all[i], nil
+	}
+	return nil, nil, fmt.Errorf("slot %d is not part of series %d", slotID, series.ID)
+}
+
+// endSeriesBefore trims the series rule so it stops before t
+func endSeriesBefore(seriesID int64, rule *RRule, t time.Time) {
+	rule.Count = 0
+	rule.Until = t.Add(-time.Second).UTC()
+	db.Exec("UPDATE slot_series SET rrule = ? WHERE id = ?", rule.String(), seriesID)
+}
+
+// handleSlotSeries manages recurring slot series.
+// GET lists series, or one series with its occurrences via ?id=.
+// PUT ?id=&scope=this|following|all&slotId= edits startTime, durationMinutes
+// and capacity. DELETE with the same parameters cancels occurrences.
+// Occurrences with bookings are never moved, shrunk below their bookings or
+// deleted; they are reported back as protected.
+func handleSlotSeries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	seriesID, _ := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+	slotID, _ := strconv.ParseInt(q.Get("slotId"), 10, 64)
+	scope := q.Get("scope")
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		if seriesID != 0 {
+			series, err := getSeries(seriesID)
+			if err != nil {
+				writeError(w, http.StatusNotFound, "Series not found")
+				return
+			}
+			series.Occurrences, _ = seriesOccurrences(seriesID, time.Time{})
+			series.StartTime = farmTime(series.StartTime)
+			json.NewEncoder(w).Encode(series)
+			return
+		}
+
+		rows, err := db.Query("SELECT id FROM slot_series ORDER BY start_time DESC")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			rows.Scan(&id)
+			ids = append(ids, id)
+		}
+		rows.Close()
+
+		var list []SlotSeries
+		for _, id := range ids {
+			if s, err := getSeries(id); err == nil {
+				s.StartTime = farmTime(s.StartTime)
+				list = append(list, *s)
+			}
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	series, err := getSeries(seriesID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Series not found")
+		return
+	}
+	rule, err := parseRRule(series.RRule)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Stored rule is invalid: "+err.Error())
+		return
+	}
+	occurrences, target, err := scopedOccurrences(series, scope, slotID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	protected := []SlotProtected{}
+
+	if r.Method == http.MethodDelete {
+		cancelled := []int64{}
+		for _, s := range occurrences {
+			if s.Booked > 0 || slotHasBookings(s.ID) {
+				protected = append(protected, SlotProtected{SlotID: s.ID, Reason: "slot has bookings"})
+				continue
+			}
+			db.Exec("DELETE FROM slot_resources WHERE slot_id = ?", s.ID)
+			db.Exec("DELETE FROM slots WHERE id = ?", s.ID)
+			cancelled = append(cancelled, s.ID)
+		}
+
+		// Record the change on the series so the rule still describes its slots
+		switch scope {
+		case "this":
+			if len(cancelled) == 1 {
+				start, _ := parseSlotTime(target.StartTime)
+				series.ExDates = append(series.ExDates, start.UTC().Format(time.RFC3339))
+				exJSON, _ := json.Marshal(series.ExDates)
+				db.Exec("UPDATE slot_series SET exdates = ? WHERE id = ?", string(exJSON), series.ID)
+			}
+		case "following":
+			if len(protected) == 0 {
+				start, _ := parseSlotTime(target.StartTime)
+				endSeriesBefore(series.ID, rule, start)
+			}
+		case "all":
+			var remaining int
+			db.QueryRow("SELECT COUNT(*) FROM slots WHERE series_id = ?", series.ID).Scan(&remaining)
+			if remaining == 0 {
+				db.Exec("DELETE FROM slot_series WHERE id = ?", series.ID)
+			}
+		}
+
+		log.Printf("🗓️  Series #%d: cancelled %d occurrence(s) (%s), %d protected", series.ID, len(cancelled), scope, len(protected))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   len(protected) == 0,
+			"cancelled": cancelled,
+			"protected": protected,
+		})
+		return
+	}
+
+	// PUT: edit occurrences
+	var req struct {
+		StartTime       string `json:"startTime"` // for following/all only the farm-local time of day is used
+		DurationMinutes int    `json:"durationMinutes" validate:"min=0,max=1440"`
+		Capacity        int    `json:"capacity" validate:"min=0"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var newStart time.Time
+	if req.StartTime != "" {
+		newStart, err = parseFarmTime(req.StartTime)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid date format: "+err.Error())
+			return
+		}
+	}
+
+	updated := []int64{}
+	conflicts := []SlotConflict{}
+	for _, s := range occurrences {
+		oldStart, err1 := parseSlotTime(s.StartTime)
+		oldEnd, err2 := parseSlotTime(s.EndTime)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		start := oldStart
+		if !newStart.IsZero() {
+			if scope == "this" {
+				start = newStart
+			} else {
+				start = atFarmClock(oldStart, newStart)
+			}
+		}
+		duration := oldEnd.Sub(oldStart)
+		if req.DurationMinutes > 0 {
+			duration = time.Duration(req.DurationMinutes) * time.Minute
+		}
+		end := start.Add(duration)
+		capacity := s.Capacity
+		if req.Capacity > 0 {
+			capacity = req.Capacity
+		}
+
+		timeChanged := !start.Equal(oldStart) || !end.Equal(oldEnd)
+		if timeChanged && (s.Booked > 0 || slotHasBookings(s.ID)) {
+			protected = append(protected, SlotProtected{SlotID: s.ID, Reason: "slot has bookings, time not changed"})
+			continue
+		}
+		if capacity < s.Booked {
+			protected = append(protected, SlotProtected{SlotID: s.ID, Reason: fmt.Sprintf("capacity cannot go below %d booked", s.Booked)})
+			continue
+		}
+
+		if timeChanged {
+			resourceIDs := []int64{}
+			rows, err := db.Query("SELECT resource_id FROM slot_resources WHERE slot_id = ?", s.ID)
+			if err == nil {
+				for rows.Next() {
+					var id int64
+					rows.Scan(&id)
+					resourceIDs = append(resourceIDs, id)
+				}
+				rows.Close()
+			}
+			c, err := findSlotConflicts(db, start, end, resourceIDs, s.ID)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if len(c) > 0 {
+				conflicts = append(conflicts, c...)
+				continue
+			}
+		}
+
+		_, err := db.Exec("UPDATE slots SET start_time = ?, end_time = ?, capacity = ? WHERE id = ?",
+			dbTime(start), dbTime(end), capacity, s.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		updated = append(updated, s.ID)
+		if capacity > s.Capacity {
+			offerFreedSeats(s.ID)
+		}
+	}
+
+	// Keep the series definition in step with its occurrences
+	resultSeriesID := series.ID
+	if scope != "this" && len(occurrences) > 0 {
+		dtstart, _ := parseSlotTime(series.StartTime)
+		first, _ := parseSlotTime(occurrences[0].StartTime)
+		if scope == "following" {
+			first, _ = parseSlotTime(target.StartTime)
+		}
+		if !newStart.IsZero() {
+			dtstart = atFarmClock(dtstart, newStart)
+		}
+		duration := series.DurationMinutes
+		if req.DurationMinutes > 0 {
+			duration = req.DurationMinutes
+		}
+		capacity := series.Capacity
+		if req.Capacity > 0 {
+			capacity = req.Capacity
+		}
+
+		if scope == "following" {
+			// Split: the original series ends before the target, a new series
+			// takes over the target and everything after it
+			last, _ := parseSlotTime(occurrences[len(occurrences)-1].StartTime)
+			newRule := *rule
+			newRule.Count = 0
+			lastDay := last.In(farmLocation)
+			newRule.Until = time.Date(lastDay.Year(), lastDay.Month(), lastDay.Day(), 23, 59, 59, 0, farmLocation).UTC() // survives a time-of-day change
+			newDtstart := first
+			if !newStart.IsZero() {
+				newDtstart = atFarmClock(first, newStart)
+			}
+			exJSON, _ := json.Marshal(series.ExDates)
+			resJSON, _ := json.Marshal(series.ResourceIDs)
+			res, err := db.Exec(`INSERT INTO slot_series (activity, start_time, duration_minutes, capacity, rrule, exdates, resource_ids)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`, series.Activity, dbTime(newDtstart), duration, capacity, newRule.String(), string(exJSON), string(resJSON))
+			if err == nil {
+				resultSeriesID, _ = res.LastInsertId()
+				for _, s := range occurrences {
+					db.Exec("UPDATE slots SET series_id = ? WHERE id = ?", resultSeriesID, s.ID)
+				}
+				endSeriesBefore(series.ID, rule, first)
+			}
+		} else {
+			db.Exec("UPDATE slot_series SET start_time = ?, duration_minutes = ?, capacity = ? WHERE id = ?",
+				dbTime(dtstart), duration, capacity, series.ID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   len(protected) == 0 && len(conflicts) == 0,
+		"seriesId":  resultSeriesID,
+		"updated":   updated,
+		"protected": protected,
+		"conflicts": conflicts,
+	})
+}
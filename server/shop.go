@@ -0,0 +1,905 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Product is something sold in the farm shop: juice, cider, preserves
+type Product struct {
+	ID                int64             `json:"id"`
+	SKU               string            `json:"sku"`
+	Name              string            `json:"name"` // Swedish default name
+	Names             map[string]string `json:"names"`
+	Descriptions      map[string]string `json:"descriptions"`
+	Price             float64           `json:"price" validate:"min=0.01"`          // consumer price in EUR, VAT included
+	VATRate           float64           `json:"vatRate" validate:"min=0,max=100"`   // percent, e.g. 14 for food
+	Stock             int               `json:"stock" validate:"min=0"`             // on the shelf; changed through stock movements
+	Reserved          int               `json:"reserved"`                           // held by unpaid orders
+	Available         int               `json:"available"`                          // stock minus reserved
+	LowStockThreshold int               `json:"lowStockThreshold" validate:"min=0"` // alert staff when available drops to this
+	Images            []string          `json:"images"`
+	Shippable         bool              `json:"shippable"` // false = pickup at the farm only (e.g. cider)
+	Active            bool              `json:"active"`
+}
+
+// CartLine is one product in a cart or an order, priced at the time it was added
+type CartLine struct {
+	ProductID int64   `json:"productId"`
+	SKU       string  `json:"sku"`
+	Name      string  `json:"name"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unitPrice"`
+	VATRate   float64 `json:"vatRate"`
+	LineTotal float64 `json:"lineTotal"`
+	Shippable bool    `json:"shippable,omitempty"`
+}
+
+// Cart is a visitor's basket, identified by the token kept in the browser
+type Cart struct {
+	Token     string     `json:"token"`
+	Items     []CartLine `json:"items"`
+	Subtotal  float64    `json:"subtotal"`
+	VATAmount float64    `json:"vatAmount"`
+}
+
+// Address is where a shipped order goes
+type Address struct {
+	Street     string `json:"street" validate:"max=200"`
+	PostalCode string `json:"postalCode" validate:"max=20"`
+	City       string `json:"city" validate:"max=100"`
+	Country    string `json:"country" validate:"max=100"`
+}
+
+// requireAddress lists the parts a shipping address is missing
+func requireAddress(a Address) fieldErrors {
+	errs := fieldErrors{}
+	for field, value := range map[string]string{"street": a.Street, "postalCode": a.PostalCode, "city": a.City} {
+		if strings.TrimSpace(value) == "" {
+			errs["address."+field] = "is required for shipping"
+		}
+	}
+	return errs
+}
+
+// Order is a paid or pending farm shop purchase
+type Order struct {
+	ID            int64      `json:"id"`
+	CustomerName  string     `json:"customerName"`
+	CustomerEmail string     `json:"customerEmail"`
+	Phone         string     `json:"phone"`
+	Delivery      string     `json:"delivery"` // pickup, shipping
+	Address       *Address   `json:"address,omitempty"`
+	Items         []CartLine `json:"items"`
+	Subtotal      float64    `json:"subtotal"`
+	ShippingFee   float64    `json:"shippingFee"`
+	VATAmount     float64    `json:"vatAmount"`
+	Total         float64    `json:"total"`
+	Status        string     `json:"status"` // pending, paid, ready, collected, shipped, cancelled, expired
+	Locale        string     `json:"locale"` // language the order was placed in
+	CreatedAt     string     `json:"createdAt"`
+}
+
+var (
+	skuPattern       = regexp.MustCompile(`^[A-Z0-9][A-Z0-9-]*$`)
+	productSelectSQL = `SELECT id, sku, name, price, vat_rate, stock, reserved, low_stock_threshold, images, shippable, active FROM products`
+	orderSelectSQL   = `SELECT id, customer_name, customer_email, phone, delivery, ship_street, ship_postal_code, ship_city, ship_country,
+		subtotal, shipping_fee, vat_amount, total, status, COALESCE(locale, ''), created_at FROM orders`
+)
+
+// shippingVATRate is the general Finnish VAT rate, applied to the shipping fee
+const shippingVATRate = 25.5
+
+func initShopTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS products (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		sku TEXT UNIQUE,
+		name TEXT,
+		price REAL DEFAULT 0,
+		vat_rate REAL DEFAULT 14,
+		stock INTEGER DEFAULT 0,
+		reserved INTEGER DEFAULT 0,
+		low_stock_threshold INTEGER DEFAULT 10,
+		low_stock_notified_at DATETIME,
+		images TEXT DEFAULT '[]',
+		shippable BOOLEAN DEFAULT 1,
+		active BOOLEAN DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS product_translations (
+		product_id INTEGER,
+		locale TEXT,
+		name TEXT,
+		description TEXT,
+		PRIMARY KEY (product_id, locale),
+		FOREIGN KEY(product_id) REFERENCES products(id)
+	);
+	CREATE TABLE IF NOT EXISTS carts (
+		token TEXT PRIMARY KEY,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS cart_items (
+		cart_token TEXT,
+		product_id INTEGER,
+		quantity INTEGER,
+		PRIMARY KEY (cart_token, product_id),
+		FOREIGN KEY(cart_token) REFERENCES carts(token),
+		FOREIGN KEY(product_id) REFERENCES products(id)
+	);
+	CREATE TABLE IF NOT EXISTS orders (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		customer_name TEXT,
+		customer_email TEXT,
+		phone TEXT DEFAULT '',
+		delivery TEXT,
+		ship_street TEXT DEFAULT '',
+		ship_postal_code TEXT DEFAULT '',
+		ship_city TEXT DEFAULT '',
+		ship_country TEXT DEFAULT '',
+		subtotal REAL,
+		shipping_fee REAL DEFAULT 0,
+		vat_amount REAL,
+		total REAL,
+		status TEXT DEFAULT 'pending',
+		access_token TEXT UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		paid_at DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS order_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		order_id INTEGER,
+		product_id INTEGER,
+		sku TEXT,
+		name TEXT,
+		quantity INTEGER,
+		unit_price REAL,
+		vat_rate REAL,
+		FOREIGN KEY(order_id) REFERENCES orders(id),
+		FOREIGN KEY(product_id) REFERENCES products(id)
+	);
+	`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating shop tables: %v", err)
+	}
+
+	// Migrations: stock reservation and low-stock alerts
+	db.Exec("ALTER TABLE products ADD COLUMN reserved INTEGER DEFAULT 0")
+	db.Exec("ALTER TABLE products ADD COLUMN low_stock_threshold INTEGER DEFAULT 10")
+	db.Exec("ALTER TABLE products ADD COLUMN low_stock_notified_at DATETIME")
+	initStockTables()
+
+	// Seed the juices listed on the products page plus cider and preserves
+	seed := []Product{
+		{SKU: "JUICE-AMOROSA-750", Name: "Amorosa äppelmust 750 ml", Price: 12, VATRate: 14, Stock: 120, Shippable: true,
+			Names:        map[string]string{"fi": "Amorosa omenamehu 750 ml", "en": "Amorosa apple juice 750 ml"},
+			Descriptions: map[string]string{"sv": "Fruktig och aromatisk med toner av persika och passionsfrukt.", "en": "Fruity and aromatic with notes of peach and passion fruit."}},
+		{SKU: "JUICE-COLLINA-750", Name: "Collina äppelmust 750 ml", Price: 12, VATRate: 14, Stock: 120, Shippable: true,
+			Names:        map[string]string{"fi": "Collina omenamehu 750 ml", "en": "Collina apple juice 750 ml"},
+			Descriptions: map[string]string{"sv": "Söt och blommig med toner av päron och jordgubb.", "en": "Sweet and floral with hints of pear and strawberry."}},
+		{SKU: "JUICE-DISCOVERY-750", Name: "Discovery äppelmust 750 ml", Price: 14, VATRate: 14, Stock: 80, Shippable: true,
+			Names:        map[string]string{"fi": "Discovery omenamehu 750 ml", "en": "Discovery apple juice 750 ml"},
+			Descriptions: map[string]string{"sv": "Rosa must med toner av litchi, aprikos och päron.", "en": "Rosy juice with hints of lychee, apricot and pear."}},
+		{SKU: "JUICE-EVALOTTA-750", Name: "Eva-Lotta äppelmust 750 ml", Price: 16, VATRate: 14, Stock: 60, Shippable: true,
+			Names:        map[string]string{"fi": "Eva-Lotta omenamehu 750 ml", "en": "Eva-Lotta apple juice 750 ml"},
+			Descriptions: map[string]string{"sv": "Prisbelönt must med livlig syra och citruskaraktär.", "en": "Award-winning juice with bright acidity and citrus character."}},
+		{SKU: "JUICE-JULYRED-750", Name: "Julyred äppelmust 750 ml", Price: 12, VATRate: 14, Stock: 100, Shippable: true,
+			Names: map[string]string{"fi": "Julyred omenamehu 750 ml", "en": "Julyred apple juice 750 ml"}},
+		{SKU: "CIDER-DRY-330", Name: "Torr cider 330 ml", Price: 6.5, VATRate: 25.5, Stock: 200, Shippable: false,
+			Names:        map[string]string{"fi": "Kuiva siideri 330 ml", "en": "Dry cider 330 ml"},
+			Descriptions: map[string]string{"sv": "Hämtas på gården, alkoholdrycker skickas inte.", "en": "Pickup at the farm only, we do not ship alcohol."}},
+		{SKU: "JAM-APPLE-300", Name: "Äppelmarmelad 300 g", Price: 7, VATRate: 14, Stock: 50, Shippable: true,
+			Names: map[string]string{"fi": "Omenamarmeladi 300 g", "en": "Apple marmalade 300 g"}},
+	}
+	for _, p := range seed {
+		var exists int
+		db.QueryRow("SELECT COUNT(*) FROM products WHERE sku = ?", p.SKU).Scan(&exists)
+		if exists > 0 {
+			continue
+		}
+		p.Active = true
+		p.LowStockThreshold = defaultLowStockThreshold
+		p.Names["sv"] = p.Name
+		if err := saveProduct(&p); err != nil {
+			log.Printf("Error seeding product %s: %v", p.SKU, err)
+		}
+	}
+}
+
+// roundCents rounds a euro amount to whole cents
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// vatIncluded returns the VAT part of a VAT-inclusive amount
+func vatIncluded(gross, rate float64) float64 {
+	return gross * rate / (100 + rate)
+}
+
+// scanProduct reads one row selected with productSelectSQL
+func scanProduct(row interface{ Scan(...interface{}) error }) (*Product, error) {
+	var p Product
+	var images string
+	if err := row.Scan(&p.ID, &p.SKU, &p.Name, &p.Price, &p.VATRate, &p.Stock, &p.Reserved, &p.LowStockThreshold, &images, &p.Shippable, &p.Active); err != nil {
+		return nil, err
+	}
+	p.Available = p.Stock - p.Reserved
+	json.Unmarshal([]byte(images), &p.Images)
+	return &p, nil
+}
+
+// loadProductTranslations fills the localized names and descriptions
+func loadProductTranslations(q queryer, p *Product) error {
+	p.Names = map[string]string{}
+	p.Descriptions = map[string]string{}
+	rows, err := q.Query("SELECT locale, name, description FROM product_translations WHERE product_id = ?", p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var locale, name, desc string
+		if err := rows.Scan(&locale, &name, &desc); err != nil {
+			continue
+		}
+		if name != "" {
+			p.Names[locale] = name
+		}
+		if desc != "" {
+			p.Descriptions[locale] = desc
+		}
+	}
+	return nil
+}
+
+// getProductBySKU loads a product with its translations
+func getProductBySKU(q queryer, sku string) (*Product, error) {
+	p, err := scanProduct(q.QueryRow(productSelectSQL+" WHERE sku = ?", sku))
+	if err != nil {
+		return nil, err
+	}
+	if err := loadProductTranslations(q, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// listProducts returns the shop catalog. Without includeInactive it is the
+// public list: active products that are not sold out.
+func listProducts(includeInactive bool) ([]Product, error) {
+	query := productSelectSQL
+	if !includeInactive {
+		query += " WHERE active = 1 AND stock - reserved > 0"
+	}
+	rows, err := db.Query(query + " ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	var products []Product
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			continue
+		}
+		products = append(products, *p)
+	}
+	rows.Close()
+
+	for i := range products {
+		loadProductTranslations(db, &products[i])
+	}
+	return products, nil
+}
+
+// Validate checks the catalog fields before saving
+func (p *Product) Validate() error {
+	p.SKU = strings.ToUpper(strings.TrimSpace(p.SKU))
+	if !skuPattern.MatchString(p.SKU) {
+		return fmt.Errorf("sku must be uppercase letters, digits and dashes")
+	}
+	if p.Name == "" {
+		p.Name = p.Names["sv"]
+	}
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	return nil
+}
+
+// saveProduct inserts or updates a product together with its translations.
+// Stock is only written for new products, after that it changes through
+// recordStockMovement so every change has a reason.
+func saveProduct(p *Product) error {
+	if p.Images == nil {
+		p.Images = []string{}
+	}
+	images, _ := json.Marshal(p.Images)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if p.ID == 0 {
+		res, err := tx.Exec("INSERT INTO products (sku, name, price, vat_rate, stock, low_stock_threshold, images, shippable, active) VALUES (?, ?, ?, ?, 0, ?, ?, ?, ?)",
+			p.SKU, p.Name, p.Price, p.VATRate, p.LowStockThreshold, string(images), p.Shippable, p.Active)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		p.ID, _ = res.LastInsertId()
+		if p.Stock > 0 {
+			if err := recordStockMovement(tx, StockMovement{ProductID: p.ID, Kind: "adjustment", Quantity: p.Stock, Reason: "Opening stock"}); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	} else {
+		_, err := tx.Exec("UPDATE products SET name=?, price=?, vat_rate=?, low_stock_threshold=?, images=?, shippable=?, active=? WHERE id=?",
+			p.Name, p.Price, p.VATRate, p.LowStockThreshold, string(images), p.Shippable, p.Active, p.ID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	tx.Exec("DELETE FROM product_translations WHERE product_id = ?", p.ID)
+	for _, locale := range activityLocales {
+		name, desc := p.Names[locale], p.Descriptions[locale]
+		if name == "" && desc == "" {
+			continue
+		}
+		if _, err := tx.Exec("INSERT INTO product_translations (product_id, locale, name, description) VALUES (?, ?, ?, ?)", p.ID, locale, name, desc); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// handleProducts serves the shop catalog.
+// GET lists active products (?all=1 includes inactive, ?sku= fetches one),
+// POST creates, PUT ?sku= updates and DELETE ?sku= deactivates so past orders
+// keep their reference.
+func handleProducts(w http.ResponseWriter, r *http.Request) {
+	sku := strings.ToUpper(pathParam(r, "sku"))
+
+	switch r.Method {
+	case http.MethodGet:
+		if sku != "" {
+			p, err := getProductBySKU(db, sku)
+			if err != nil {
+				writeError(w, http.StatusNotFound, "Product not found")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(p)
+			return
+		}
+
+		products, err := listProducts(r.URL.Query().Get("all") == "1")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(products)
+
+	case http.MethodPost, http.MethodPut:
+		var p Product
+		p.Active = true
+		p.Shippable = true
+		p.LowStockThreshold = defaultLowStockThreshold
+		if !decodeJSON(w, r, &p) {
+			return
+		}
+
+		if r.Method == http.MethodPut {
+			existing, err := getProductBySKU(db, sku)
+			if err != nil {
+				writeError(w, http.StatusNotFound, "Product not found")
+				return
+			}
+			p.ID = existing.ID
+			p.SKU = existing.SKU // SKUs are printed on labels and order receipts
+		}
+
+		if err := p.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := saveProduct(&p); err != nil {
+			if strings.Contains(err.Error(), "UNIQUE") {
+				writeError(w, http.StatusConflict, "SKU already exists: "+p.SKU)
+				return
+			}
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "product": p})
+
+	case http.MethodDelete:
+		res, err := db.Exec("UPDATE products SET active = 0 WHERE sku = ?", sku)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, http.StatusNotFound, "Product not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// loadCart prices the cart's items at the current catalog prices
+func loadCart(q queryer, token string) (*Cart, error) {
+	var exists int
+	if err := q.QueryRow("SELECT COUNT(*) FROM carts WHERE token = ?", token).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	rows, err := q.Query(`
+		SELECT p.id, p.sku, p.name, ci.quantity, p.price, p.vat_rate, p.shippable
+		FROM cart_items ci JOIN products p ON ci.product_id = p.id
+		WHERE ci.cart_token = ? AND p.active = 1
+		ORDER BY p.id ASC`, token)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cart := &Cart{Token: token, Items: []CartLine{}}
+	for rows.Next() {
+		var l CartLine
+		if err := rows.Scan(&l.ProductID, &l.SKU, &l.Name, &l.Quantity, &l.UnitPrice, &l.VATRate, &l.Shippable); err != nil {
+			continue
+		}
+		l.LineTotal = roundCents(l.UnitPrice * float64(l.Quantity))
+		cart.Subtotal += l.LineTotal
+		cart.VATAmount += vatIncluded(l.LineTotal, l.VATRate)
+		cart.Items = append(cart.Items, l)
+	}
+	cart.Subtotal = roundCents(cart.Subtotal)
+	cart.VATAmount = roundCents(cart.VATAmount)
+	return cart, nil
+}
+
+// handleCart manages a shopping cart.
+// POST creates an empty cart and returns its token; GET ?token= returns it
+// priced; PUT ?token= {"sku", "quantity"} sets one line, 0 removes it.
+func handleCart(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	switch r.Method {
+	case http.MethodPost:
+		token = newToken()
+		if _, err := db.Exec("INSERT INTO carts (token) VALUES (?)", token); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+	case http.MethodPut:
+		var req struct {
+			SKU      string `json:"sku" validate:"required,max=40"`
+			Quantity int    `json:"quantity" validate:"min=0,max=1000"` // 0 removes the line
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if _, err := loadCart(db, token); err != nil {
+			writeError(w, http.StatusNotFound, "Cart not found")
+			return
+		}
+		p, err := getProductBySKU(db, strings.ToUpper(req.SKU))
+		if err != nil || !p.Active {
+			writeError(w, http.StatusNotFound, "Product not found")
+			return
+		}
+		if req.Quantity > p.Available {
+			writeError(w, http.StatusConflict, fmt.Sprintf("Only %d of %s left", max(p.Available, 0), p.Name))
+			return
+		}
+
+		if req.Quantity == 0 {
+			_, err = db.Exec("DELETE FROM cart_items WHERE cart_token = ? AND product_id = ?", token, p.ID)
+		} else {
+			_, err = db.Exec(`INSERT INTO cart_items (cart_token, product_id, quantity) VALUES (?, ?, ?)
+				ON CONFLICT(cart_token, product_id) DO UPDATE SET quantity = excluded.quantity`, token, p.ID, req.Quantity)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		db.Exec("UPDATE carts SET updated_at = CURRENT_TIMESTAMP WHERE token = ?", token)
+
+	case http.MethodGet:
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	cart, err := loadCart(db, token)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Cart not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cart)
+}
+
+// shippingFee is the flat fee for shipped orders, SHOP_SHIPPING_FEE (EUR)
+func shippingFee() float64 {
+	return envFloat("SHOP_SHIPPING_FEE", 9.90)
+}
+
+// loadOrder reads an order and its lines
+func loadOrder(q queryer, where string, arg interface{}) (*Order, error) {
+	var o Order
+	var a Address
+	err := q.QueryRow(orderSelectSQL+" WHERE "+where, arg).Scan(&o.ID, &o.CustomerName, &o.CustomerEmail, &o.Phone, &o.Delivery,
+		&a.Street, &a.PostalCode, &a.City, &a.Country, &o.Subtotal, &o.ShippingFee, &o.VATAmount, &o.Total, &o.Status, &o.Locale, &o.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if o.Delivery == "shipping" {
+		o.Address = &a
+	}
+
+	rows, err := q.Query("SELECT COALESCE(product_id, 0), sku, name, quantity, unit_price, vat_rate FROM order_items WHERE order_id = ? ORDER BY id ASC", o.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	o.Items = []CartLine{}
+	for rows.Next() {
+		var l CartLine
+		if err := rows.Scan(&l.ProductID, &l.SKU, &l.Name, &l.Quantity, &l.UnitPrice, &l.VATRate); err != nil {
+			continue
+		}
+		l.LineTotal = roundCents(l.UnitPrice * float64(l.Quantity))
+		o.Items = append(o.Items, l)
+	}
+	return &o, nil
+}
+
+// handleOrders places and manages farm shop orders.
+// POST turns a cart into a pending order and returns what the payment page needs;
+// GET ?token= shows one order to its customer, GET without a token lists
+// orders for staff (?status= filters); PUT ?id= {"status"} moves an order
+// through fulfilment.
+func handleOrders(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		createOrder(w, r)
+
+	case http.MethodGet:
+		if token := r.URL.Query().Get("token"); token != "" {
+			o, err := loadOrder(db, "access_token = ?", token)
+			if err != nil {
+				writeError(w, http.StatusNotFound, "Order not found")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(o)
+			return
+		}
+
+		query := "SELECT id FROM orders"
+		args := []interface{}{}
+		if status := r.URL.Query().Get("status"); status != "" {
+			query += " WHERE status = ?"
+			args = append(args, status)
+		}
+		rows, err := db.Query(query+" ORDER BY created_at DESC, id DESC", args...)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if rows.Scan(&id) == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+
+		orders := []Order{}
+		for _, id := range ids {
+			if o, err := loadOrder(db, "id = ?", id); err == nil {
+				orders = append(orders, *o)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(orders)
+
+	case http.MethodPut:
+		id, _ := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+		var req struct {
+			Status string `json:"status" validate:"required,oneof=ready|collected|shipped"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		o, err := loadOrder(db, "id = ?", id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Order not found")
+			return
+		}
+
+		// Fulfilment only moves forward from a paid order
+		allowed := map[string][]string{
+			"ready":     {"paid"},
+			"collected": {"paid", "ready"},
+			"shipped":   {"paid"},
+		}
+		from, ok := allowed[req.Status]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "status must be ready, collected or shipped")
+			return
+		}
+		if (req.Status == "shipped") != (o.Delivery == "shipping") {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("A %s order cannot be marked %s", o.Delivery, req.Status))
+			return
+		}
+		valid := false
+		for _, s := range from {
+			valid = valid || s == o.Status
+		}
+		if !valid {
+			writeError(w, http.StatusConflict, fmt.Sprintf("Order is %s, cannot mark it %s", o.Status, req.Status))
+			return
+		}
+
+		if _, err := db.Exec("UPDATE orders SET status = ? WHERE id = ?", req.Status, id); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if req.Status == "ready" || req.Status == "shipped" {
+			sendCustomerEmail(o.CustomerEmail, preferredLocale(o.CustomerEmail, o.Locale), "order_"+req.Status, map[string]interface{}{
+				"Name":    o.CustomerName,
+				"OrderID": o.ID,
+				"Address": o.Address,
+			})
+		}
+		log.Printf("📦 Order #%d marked %s", id, req.Status)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// createOrder checks the cart against stock and delivery rules and stores a
+// pending order with the prices the customer saw
+func createOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CartToken string  `json:"cartToken" validate:"required,max=64"`
+		Name      string  `json:"name" validate:"required,max=200"`
+		Email     string  `json:"email" validate:"required,email"`
+		Phone     string  `json:"phone" validate:"max=40"`
+		Delivery  string  `json:"delivery" validate:"required,oneof=pickup|shipping"`
+		Address   Address `json:"address"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Name, req.Email = strings.TrimSpace(req.Name), strings.TrimSpace(req.Email)
+	if req.Delivery == "shipping" {
+		if errs := requireAddress(req.Address); len(errs) > 0 {
+			writeFieldErrors(w, errs)
+			return
+		}
+	}
+	if req.Delivery == "pickup" {
+		req.Address = Address{}
+	} else if req.Address.Country == "" {
+		req.Address.Country = "FI"
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	cart, err := loadCart(tx, req.CartToken)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Cart not found")
+		return
+	}
+	if len(cart.Items) == 0 {
+		writeError(w, http.StatusBadRequest, "Cart is empty")
+		return
+	}
+
+	for _, l := range cart.Items {
+		if req.Delivery == "shipping" && !l.Shippable {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("%s can only be picked up at the farm", l.Name))
+			return
+		}
+		// Reserve now so two customers can't both pay for the last bottle
+		res, err := tx.Exec("UPDATE products SET reserved = reserved + ? WHERE id = ? AND stock - reserved >= ?", l.Quantity, l.ProductID, l.Quantity)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			var available int
+			tx.QueryRow("SELECT stock - reserved FROM products WHERE id = ?", l.ProductID).Scan(&available)
+			writeError(w, http.StatusConflict, fmt.Sprintf("Only %d of %s left", max(available, 0), l.Name))
+			return
+		}
+	}
+
+	fee, vat := 0.0, cart.VATAmount
+	if req.Delivery == "shipping" {
+		fee = shippingFee()
+		vat = roundCents(vat + vatIncluded(fee, shippingVATRate))
+	}
+	total := roundCents(cart.Subtotal + fee)
+	accessToken := newToken()
+
+	contactID, err := contactIDFor(tx, req.Email, req.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res, err := tx.Exec(`INSERT INTO orders (customer_name, customer_email, phone, delivery, ship_street, ship_postal_code, ship_city, ship_country,
+		subtotal, shipping_fee, vat_amount, total, status, access_token, locale, contact_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'pending', ?, ?, ?)`,
+		req.Name, req.Email, req.Phone, req.Delivery, req.Address.Street, req.Address.PostalCode, req.Address.City, req.Address.Country,
+		cart.Subtotal, fee, vat, total, accessToken, requestLocale(r), contactID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	orderID, _ := res.LastInsertId()
+
+	for _, l := range cart.Items {
+		if _, err := tx.Exec("INSERT INTO order_items (order_id, product_id, sku, name, quantity, unit_price, vat_rate) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			orderID, l.ProductID, l.SKU, l.Name, l.Quantity, l.UnitPrice, l.VATRate); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM cart_items WHERE cart_token = ?", req.CartToken); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("🛒 New order #%d from %s: %d line(s), €%.2f (%s)", orderID, req.Name, len(cart.Items), total, req.Delivery)
+	for _, l := range cart.Items {
+		checkLowStock(l.ProductID)
+	}
+
+	// Same shape as /api/adopt and /api/book-visit so payment.html can take over
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"id":          orderID,
+		"name":        req.Name,
+		"amount":      total,
+		"treeType":    fmt.Sprintf("Order #%d", orderID),
+		"accessToken": accessToken,
+	})
+}
+
+// handleConfirmOrder is the payment callback for shop orders, the
+// counterpart of /api/confirm-payment and /api/confirm-visit
+func handleConfirmOrder(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		OrderID    int64   `json:"orderId" validate:"min=1"`
+		AmountPaid float64 `json:"amountPaid"`
+	}
+	if !decodeJSON(w, r, &data) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE orders SET status = 'paid', paid_at = ? WHERE id = ? AND status = 'pending'", dbTime(time.Now()), data.OrderID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusConflict, "Order not found or already paid")
+		return
+	}
+	o, err := loadOrder(tx, "id = ?", data.OrderID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, l := range o.Items {
+		// The reservation turns into a sale
+		if _, err := tx.Exec("UPDATE products SET reserved = MAX(reserved - ?, 0) WHERE id = ?", l.Quantity, l.ProductID); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err := recordStockMovement(tx, StockMovement{
+			ProductID: l.ProductID, Kind: "sale", Quantity: -l.Quantity, Reason: fmt.Sprintf("Order #%d", o.ID), OrderID: o.ID,
+		}); err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+	}
+	var token string
+	tx.QueryRow("SELECT access_token FROM orders WHERE id = ?", o.ID).Scan(&token)
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("💳 MOCK: Payment received for order #%d – €%.2f", o.ID, o.Total)
+	for _, l := range o.Items {
+		checkLowStock(l.ProductID)
+	}
+	locale := preferredLocale(o.CustomerEmail, o.Locale)
+	sendCustomerEmail(o.CustomerEmail, locale, "order_receipt", orderReceipt(o, token, locale))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Order paid!",
+	})
+}
+
+// orderReceipt is the data for the receipt email with the VAT breakdown.
+// Product names are translated when the product has a name in the locale.
+func orderReceipt(o *Order, token, locale string) map[string]interface{} {
+	items := make([]CartLine, len(o.Items))
+	for i, l := range o.Items {
+		var name string
+		db.QueryRow("SELECT name FROM product_translations WHERE product_id = ? AND locale = ? AND name != ''", l.ProductID, locale).Scan(&name)
+		if name != "" {
+			l.Name = name
+		}
+		items[i] = l
+	}
+	return map[string]interface{}{
+		"Name":        o.CustomerName,
+		"OrderID":     o.ID,
+		"Items":       items,
+		"ShippingFee": o.ShippingFee,
+		"Total":       o.Total,
+		"VAT":         o.VATAmount,
+		"Pickup":      o.Delivery == "pickup",
+		"Address":     o.Address,
+		"URL":         publicURL("/shop.html?order=" + token),
+	}
+}
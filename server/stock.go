@@ -0,0 +1,310 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultLowStockThreshold is used for new products that don't set their own
+const defaultLowStockThreshold = 10
+
+// StockMovement is one change to a product's stock, positive for intake
+type StockMovement struct {
+	ID          int64  `json:"id"`
+	ProductID   int64  `json:"productId"`
+	SKU         string `json:"sku,omitempty"`
+	ProductName string `json:"productName,omitempty"`
+	Kind        string `json:"kind"` // harvest, sale, spoilage, adjustment; spoilage and adjustments need a reason
+	Quantity    int    `json:"quantity"`
+	Reason      string `json:"reason"`
+	StaffID     int64  `json:"staffId,omitempty"`
+	StaffName   string `json:"staffName,omitempty"`
+	OrderID     int64  `json:"orderId,omitempty"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+// initStockTables is called from initShopTables once the products table
+// exists, so seeded products get their opening stock recorded as movements
+func initStockTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS stock_movements (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		product_id INTEGER,
+		kind TEXT,
+		quantity INTEGER,
+		reason TEXT,
+		staff_id INTEGER,
+		order_id INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(product_id) REFERENCES products(id),
+		FOREIGN KEY(staff_id) REFERENCES staff(id),
+		FOREIGN KEY(order_id) REFERENCES orders(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_stock_movements_product ON stock_movements(product_id, created_at);`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating stock tables: %v", err)
+	}
+
+	// Products created before movements existed get their current stock as
+	// an opening balance, so the history always adds up to the stock column
+	db.Exec(`INSERT INTO stock_movements (product_id, kind, quantity, reason)
+		SELECT id, 'adjustment', stock, 'Opening stock' FROM products
+		WHERE stock > 0 AND id NOT IN (SELECT product_id FROM stock_movements)`)
+}
+
+// recordStockMovement applies a movement to the product's stock inside tx.
+// Stock never goes below zero and never below what unpaid orders have
+// reserved, except for the sale that consumes the reservation itself.
+func recordStockMovement(tx *sql.Tx, m StockMovement) error {
+	var stock, reserved int
+	var name string
+	if err := tx.QueryRow("SELECT stock, reserved, name FROM products WHERE id = ?", m.ProductID).Scan(&stock, &reserved, &name); err != nil {
+		return fmt.Errorf("product %d not found", m.ProductID)
+	}
+	if m.Kind != "sale" && stock+m.Quantity < reserved {
+		return fmt.Errorf("only %d of %s are not reserved by orders", stock-reserved, name)
+	}
+	if stock+m.Quantity < 0 {
+		return fmt.Errorf("only %d of %s in stock", stock, name)
+	}
+
+	var staffID, orderID interface{}
+	if m.StaffID != 0 {
+		staffID = m.StaffID
+	}
+	if m.OrderID != 0 {
+		orderID = m.OrderID
+	}
+	if _, err := tx.Exec("INSERT INTO stock_movements (product_id, kind, quantity, reason, staff_id, order_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		m.ProductID, m.Kind, m.Quantity, m.Reason, staffID, orderID, dbTime(time.Now())); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE products SET stock = stock + ? WHERE id = ?", m.Quantity, m.ProductID)
+	return err
+}
+
+// checkLowStock tells staff once when a product's available stock drops to
+// its threshold. Restocking above the threshold arms the alert again.
+func checkLowStock(productID int64) {
+	p, err := scanProduct(db.QueryRow(productSelectSQL+" WHERE id = ?", productID))
+	if err != nil {
+		return
+	}
+	var notified sql.NullString
+	db.QueryRow("SELECT low_stock_notified_at FROM products WHERE id = ?", productID).Scan(&notified)
+
+	if p.Available > p.LowStockThreshold {
+		if notified.Valid {
+			db.Exec("UPDATE products SET low_stock_notified_at = NULL WHERE id = ?", productID)
+		}
+		return
+	}
+	if notified.Valid {
+		return
+	}
+
+	db.Exec("UPDATE products SET low_stock_notified_at = ? WHERE id = ?", dbTime(time.Now()), productID)
+	subject := fmt.Sprintf("Lågt lager: %s", p.Name)
+	body := fmt.Sprintf("%s (%s) har %d kvar att sälja, gränsen är %d.", p.Name, p.SKU, max(p.Available, 0), p.LowStockThreshold)
+	if p.Available <= 0 {
+		subject = fmt.Sprintf("Slutsåld: %s", p.Name)
+		body = fmt.Sprintf("%s (%s) är slutsåld och visas inte längre i butiken.", p.Name, p.SKU)
+	}
+	notifyAdmin(subject, body+"\n\nLagret: "+publicURL("/admin/shop"))
+}
+
+// listStockMovements returns the history for one product, newest first
+func listStockMovements(productID int64, limit int) ([]StockMovement, error) {
+	rows, err := db.Query(`
+		SELECT m.id, m.product_id, p.sku, p.name, m.kind, m.quantity, COALESCE(m.reason, ''),
+			COALESCE(m.staff_id, 0), COALESCE(s.name, ''), COALESCE(m.order_id, 0), m.created_at
+		FROM stock_movements m
+		JOIN products p ON m.product_id = p.id
+		LEFT JOIN staff s ON m.staff_id = s.id
+		WHERE m.product_id = ?
+		ORDER BY m.created_at DESC, m.id DESC LIMIT ?`, productID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movements := []StockMovement{}
+	for rows.Next() {
+		var m StockMovement
+		if err := rows.Scan(&m.ID, &m.ProductID, &m.SKU, &m.ProductName, &m.Kind, &m.Quantity, &m.Reason,
+			&m.StaffID, &m.StaffName, &m.OrderID, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.CreatedAt = farmTime(m.CreatedAt)
+		movements = append(movements, m)
+	}
+	return movements, nil
+}
+
+// orderHoldTTL is how long an unpaid order keeps its reserved stock
+func orderHoldTTL() time.Duration { return envMinutes("ORDER_HOLD_MINUTES", 60) }
+
+// expireUnpaidOrders gives the stock reserved by abandoned orders back to the shop
+func expireUnpaidOrders() {
+	cutoff := dbTime(time.Now().Add(-orderHoldTTL()))
+	rows, err := db.Query("SELECT id FROM orders WHERE status = 'pending' AND created_at < ?", cutoff)
+	if err != nil {
+		log.Printf("Error reading expired orders: %v", err)
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := releaseOrder(id, "pending", "expired"); err != nil {
+			log.Printf("Error expiring order %d: %v", id, err)
+			continue
+		}
+		log.Printf("⌛ Order #%d was not paid in time, released its reserved stock", id)
+	}
+}
+
+// releaseOrder moves an unpaid order to another status and gives its
+// reserved stock back. It fails if the order is no longer in fromStatus.
+func releaseOrder(orderID int64, fromStatus, toStatus string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE orders SET status = ? WHERE id = ? AND status = ?", toStatus, orderID, fromStatus)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("order %d is no longer %s", orderID, fromStatus)
+	}
+	if _, err := tx.Exec(`UPDATE products SET reserved = MAX(reserved - COALESCE(
+		(SELECT SUM(quantity) FROM order_items WHERE order_id = ? AND product_id = products.id), 0), 0)
+		WHERE id IN (SELECT product_id FROM order_items WHERE order_id = ?)`, orderID, orderID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// handleStock is the staff view of the shop's stock.
+// GET lists every product with a low flag, GET ?sku= adds its movement
+// history, POST {"sku", "kind", "quantity", "reason"} records a movement
+// under the staff member whose key sent it. Sales are recorded by the
+// payment callback, not by hand.
+func handleStock(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if sku := strings.ToUpper(r.URL.Query().Get("sku")); sku != "" {
+			p, err := getProductBySKU(db, sku)
+			if err != nil {
+				writeError(w, http.StatusNotFound, "Product not found")
+				return
+			}
+			movements, err := listStockMovements(p.ID, 200)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"product": p, "movements": movements})
+			return
+		}
+
+		products, err := listProducts(true)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		type stockRow struct {
+			Product
+			Low bool `json:"low"`
+		}
+		list := []stockRow{}
+		for _, p := range products {
+			list = append(list, stockRow{Product: p, Low: p.Available <= p.LowStockThreshold})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		var req struct {
+			SKU      string `json:"sku" validate:"required,max=40"`
+			Kind     string `json:"kind" validate:"required,oneof=harvest|spoilage|adjustment"`
+			Quantity int    `json:"quantity" validate:"required,min=-100000,max=100000"`
+			Reason   string `json:"reason" validate:"max=500"`
+		}
+		staffID, ok := requireStaff(w, r)
+		if !ok {
+			return
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+
+		// Intake only adds and spoilage only removes, whichever sign was sent
+		switch req.Kind {
+		case "harvest":
+			req.Quantity = abs(req.Quantity)
+		case "spoilage":
+			req.Quantity = -abs(req.Quantity)
+		}
+		if req.Reason == "" && req.Kind != "harvest" {
+			writeError(w, http.StatusBadRequest, "reason is required for "+req.Kind)
+			return
+		}
+		var staffName string
+		db.QueryRow("SELECT name FROM staff WHERE id = ?", staffID).Scan(&staffName)
+		p, err := getProductBySKU(db, strings.ToUpper(req.SKU))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Product not found")
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer tx.Rollback()
+		if err := recordStockMovement(tx, StockMovement{
+			ProductID: p.ID, Kind: req.Kind, Quantity: req.Quantity, Reason: req.Reason, StaffID: staffID,
+		}); err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		log.Printf("📦 Stock %s %+d %s by %s: %s", req.Kind, req.Quantity, p.SKU, staffName, req.Reason)
+		checkLowStock(p.ID)
+
+		p, _ = getProductBySKU(db, p.SKU)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "product": p})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
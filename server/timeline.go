@@ -0,0 +1,209 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A customer's timeline gathers everything that happened to the person from
+// the tables that record it, by their contact (see contacts.go), so bookings
+// and orders made under another address they use show up too.
+
+// TimelineEntry is one event in a customer's history
+type TimelineEntry struct {
+	At     time.Time `json:"at"`
+	Kind   string    `json:"kind"`             // activity, booking, order, payment, inquiry, email, feedback
+	Action string    `json:"action,omitempty"` // activity_log action, for the icon
+	Title  string    `json:"title"`
+	Detail string    `json:"detail,omitempty"`
+	Amount float64   `json:"amount,omitempty"`
+	Ref    int64     `json:"ref"` // id in the entry's own table
+}
+
+// customerTimeline lists a customer's history, newest first
+func customerTimeline(c *Customer) ([]TimelineEntry, error) {
+	var entries []TimelineEntry
+	sources := []func(*Customer) ([]TimelineEntry, error){
+		timelineActivity, timelineBookings, timelineOrders, timelineInquiries, timelineEmails, timelineFeedback,
+	}
+	for _, source := range sources {
+		found, err := source(c)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].At.After(entries[j].At) })
+	return entries, nil
+}
+
+func timelineActivity(c *Customer) ([]TimelineEntry, error) {
+	rows, err := db.Query(`SELECT id, COALESCE(action, ''), COALESCE(message, ''), created_at
+		FROM activity_log WHERE customer_id = ? OR contact_id = ?`, c.ID, c.ContactID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []TimelineEntry
+	for rows.Next() {
+		e := TimelineEntry{Kind: "activity"}
+		if err := rows.Scan(&e.Ref, &e.Action, &e.Title, &e.At); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func timelineBookings(c *Customer) ([]TimelineEntry, error) {
+	rows, err := db.Query(`
+		SELECT b.id, b.created_at, COALESCE(s.activity, ''), s.start_time, b.quantity, COALESCE(b.status, ''), COALESCE(b.total_amount, 0)
+		FROM bookings b
+		LEFT JOIN slots s ON s.id = b.slot_id
+		WHERE b.contact_id = ?`, c.ContactID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []TimelineEntry
+	for rows.Next() {
+		e := TimelineEntry{Kind: "booking"}
+		var activity, status string
+		var start sql.NullString
+		var quantity int
+		if err := rows.Scan(&e.Ref, &e.At, &activity, &start, &quantity, &status, &e.Amount); err != nil {
+			return nil, err
+		}
+		e.Title = fmt.Sprintf("Booked %s for %d", activityNameIn(activity, "en"), quantity)
+		e.Detail = status
+		if start.Valid {
+			e.Detail = farmTime(start.String) + ", " + status
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// timelineOrders adds each shop order, and its payment when it was paid
+func timelineOrders(c *Customer) ([]TimelineEntry, error) {
+	rows, err := db.Query(`SELECT id, created_at, paid_at, COALESCE(status, ''), COALESCE(total, 0)
+		FROM orders WHERE contact_id = ?`, c.ContactID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []TimelineEntry
+	for rows.Next() {
+		var id int64
+		var created time.Time
+		var paid sql.NullTime
+		var status string
+		var total float64
+		if err := rows.Scan(&id, &created, &paid, &status, &total); err != nil {
+			return nil, err
+		}
+		entries = append(entries, TimelineEntry{At: created, Kind: "order", Title: fmt.Sprintf("Order #%d", id),
+			Detail: status, Amount: total, Ref: id})
+		if paid.Valid {
+			entries = append(entries, TimelineEntry{At: paid.Time, Kind: "payment", Title: fmt.Sprintf("Paid order #%d", id),
+				Amount: total, Ref: id})
+		}
+	}
+	return entries, rows.Err()
+}
+
+func timelineInquiries(c *Customer) ([]TimelineEntry, error) {
+	rows, err := db.Query(`SELECT id, created_at, COALESCE(activity, ''), COALESCE(status, ''), COALESCE(message, '')
+		FROM inquiries WHERE contact_id = ?`, c.ContactID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []TimelineEntry
+	for rows.Next() {
+		e := TimelineEntry{Kind: "inquiry"}
+		var activity, status string
+		if err := rows.Scan(&e.Ref, &e.At, &activity, &status, &e.Detail); err != nil {
+			return nil, err
+		}
+		e.Title = fmt.Sprintf("Asked about a private %s visit (%s)", activityNameIn(activity, "en"), status)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func timelineEmails(c *Customer) ([]TimelineEntry, error) {
+	rows, err := db.Query(`SELECT id, sent_at, COALESCE(subject, '')
+		FROM emails WHERE contact_id = ?`, c.ContactID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []TimelineEntry
+	for rows.Next() {
+		e := TimelineEntry{Kind: "email"}
+		if err := rows.Scan(&e.Ref, &e.At, &e.Title); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func timelineFeedback(c *Customer) ([]TimelineEntry, error) {
+	rows, err := db.Query(`SELECT id, created_at, COALESCE(survey_type, ''), COALESCE(rating, 0), COALESCE(experience, '')
+		FROM feedback WHERE contact_id = ?`, c.ContactID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []TimelineEntry
+	for rows.Next() {
+		e := TimelineEntry{Kind: "feedback"}
+		var survey string
+		var rating int
+		if err := rows.Scan(&e.Ref, &e.At, &survey, &rating, &e.Detail); err != nil {
+			return nil, err
+		}
+		e.Title = fmt.Sprintf("Rated the %s survey %d/5", survey, rating)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// handleCustomerTimeline returns a customer's history for the admin detail
+// view: GET /api/customers/{id}/timeline
+func handleCustomerTimeline(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	c, err := getCustomer(id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "Customer not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	entries, err := customerTimeline(c)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if entries == nil {
+		entries = []TimelineEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"customer": c, "entries": entries})
+}
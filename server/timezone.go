@@ -0,0 +1,137 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+	_ "time/tzdata" // the farm zone must resolve even without system zoneinfo
+)
+
+// slotTimeLayout is how slot start and end times are stored in SQLite.
+// Stored values are always UTC, matching SQLite's CURRENT_TIMESTAMP.
+const slotTimeLayout = "2006-01-02 15:04:05"
+
+// defaultFarmTimezone is where every visit physically happens
+const defaultFarmTimezone = "Europe/Mariehamn"
+
+// farmLocation is used to interpret local input and to render API output
+var farmLocation = time.UTC
+
+// loadFarmLocation reads FARM_TIMEZONE, falling back to Europe/Mariehamn
+func loadFarmLocation() {
+	name := os.Getenv("FARM_TIMEZONE")
+	if name == "" {
+		name = defaultFarmTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Unknown FARM_TIMEZONE %q, using %s: %v", name, defaultFarmTimezone, err)
+		loc, _ = time.LoadLocation(defaultFarmTimezone)
+	}
+	farmLocation = loc
+}
+
+// dbTime formats t for storage in a DATETIME column
+func dbTime(t time.Time) string {
+	return t.UTC().Format(slotTimeLayout)
+}
+
+// parseSlotTime reads a stored slot time as returned by the SQLite driver
+func parseSlotTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse(slotTimeLayout, s)
+}
+
+// farmTime renders a stored time as RFC3339 with the farm's current offset,
+// e.g. "2027-06-05T13:00:00+03:00"
+func farmTime(s string) string {
+	t, err := parseSlotTime(s)
+	if err != nil {
+		return s
+	}
+	return t.In(farmLocation).Format(time.RFC3339)
+}
+
+// parseFarmTime accepts an offset-aware RFC3339 timestamp, or a naive local
+// time ("2006-01-02 15:04", "2006-01-02T15:04") which is read as farm time
+func parseFarmTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, farmLocation); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339 or YYYY-MM-DD HH:MM farm time", s)
+}
+
+// atFarmClock returns day's farm-local date at clock's farm-local time of
+// day, so a 10:00 series stays at 10:00 across DST changes
+func atFarmClock(day, clock time.Time) time.Time {
+	d := day.In(farmLocation)
+	c := clock.In(farmLocation)
+	return time.Date(d.Year(), d.Month(), d.Day(), c.Hour(), c.Minute(), 0, 0, farmLocation)
+}
+
+// slotTimesToUTC rewrites slot times stored before they were kept in UTC.
+// Those were naive farm times, so "2026-01-19 10:00:00" becomes
+// "2026-01-19 08:00:00" in Mariehamn winter time.
+func slotTimesToUTC(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, start_time, end_time FROM slots")
+	if err != nil {
+		return err
+	}
+	type slotTimes struct {
+		id         int64
+		start, end string
+	}
+	var slots []slotTimes
+	for rows.Next() {
+		var s slotTimes
+		var start, end sql.NullString
+		if err := rows.Scan(&s.id, &start, &end); err != nil {
+			rows.Close()
+			return err
+		}
+		s.start, s.end = start.String, end.String
+		slots = append(slots, s)
+	}
+	rows.Close()
+
+	asFarmTime := func(stored string) (string, error) {
+		t, err := parseSlotTime(stored)
+		if err != nil {
+			return "", err
+		}
+		local := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, farmLocation)
+		return dbTime(local), nil
+	}
+	for _, s := range slots {
+		if s.start == "" || s.end == "" {
+			continue
+		}
+		start, err := asFarmTime(s.start)
+		if err != nil {
+			return fmt.Errorf("slot #%d: %v", s.id, err)
+		}
+		end, err := asFarmTime(s.end)
+		if err != nil {
+			return fmt.Errorf("slot #%d: %v", s.id, err)
+		}
+		if _, err := tx.Exec("UPDATE slots SET start_time = ?, end_time = ? WHERE id = ?", start, end, s.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localize rewrites the slot's times from storage form to farm time
+func (s *Slot) localize() {
+	s.StartTime = farmTime(s.StartTime)
+	s.EndTime = farmTime(s.EndTime)
+}
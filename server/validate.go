@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Request structs declare their rules in a validate tag next to the json
+// name, and decodeJSON checks them:
+//
+//	Email    string `json:"email" validate:"required,email"`
+//	Quantity int    `json:"quantity" validate:"min=1"`
+//
+// Rules:
+//
+//	required   not empty (strings are trimmed) and not zero
+//	email      a plain address like anna@example.com
+//	min=N      numbers at least N; strings and lists at least N long
+//	max=N      numbers at most N; strings and lists at most N long
+//	oneof=a|b  one of the listed values
+//	variety,   one of the values in validationLists
+//	locale
+//
+// Empty strings and lists skip every rule but required, so optional fields
+// only need rules for when they are given. Numbers are always checked.
+// Pointer fields left out of the body skip every rule, required too, and are
+// otherwise checked like their value.
+// Nested structs and lists of structs are checked too, as "address.city" or
+// "items[2].quantity".
+
+// maxBodyBytes caps JSON request bodies; uploads have their own limit
+const maxBodyBytes = 1 << 20
+
+// validationLists are the named lists a validate tag can refer to
+var validationLists = map[string]func() []string{
+	"variety": func() []string { return treeVarieties },
+	"locale":  func() []string { return activityLocales },
+}
+
+// fieldErrors maps a field's json name to what is wrong with it
+type fieldErrors map[string]string
+
+func (e fieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		parts[i] = field + " " + e[field]
+	}
+	return strings.Join(parts, "; ")
+}
+
+// writeFieldErrors sends a 400 validation_failed error listing each field,
+// {"success": false, "error": {...}, "fields": {"email": "must be a valid email address"}}
+func writeFieldErrors(w http.ResponseWriter, errs fieldErrors) {
+	writeErrorDetails(w, http.StatusBadRequest, "validation_failed", "Invalid request: "+errs.Error(),
+		map[string]interface{}{"fields": errs})
+}
+
+// decodeJSON reads the request body into dst, at most maxBodyBytes and
+// without unknown fields, and checks its validate tags. When it returns
+// false the error response has been written.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		var tooLarge *http.MaxBytesError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &tooLarge):
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit))
+		case errors.As(err, &typeErr) && typeErr.Field != "":
+			writeFieldErrors(w, fieldErrors{typeErr.Field: "must be " + jsonTypeName(typeErr.Type)})
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+			writeFieldErrors(w, fieldErrors{field: "is not a known field"})
+		default:
+			writeError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		}
+		return false
+	}
+	if dec.More() {
+		writeError(w, http.StatusBadRequest, "Invalid JSON: more than one value in the body")
+		return false
+	}
+	if errs := validateStruct(dst); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return false
+	}
+	return true
+}
+
+// validateStruct checks the validate tags of a struct or pointer to one
+func validateStruct(v interface{}) fieldErrors {
+	errs := fieldErrors{}
+	validateValue(reflect.ValueOf(v), "", errs)
+	return errs
+}
+
+func validateValue(v reflect.Value, prefix string, errs fieldErrors) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := jsonFieldName(f)
+		if name == "-" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		fv := v.Field(i)
+
+		if tag := f.Tag.Get("validate"); tag != "" {
+			if msg := checkRules(fv, tag); msg != "" {
+				errs[name] = msg
+				continue
+			}
+		}
+
+		// Descend into nested structs and lists of them
+		switch fv.Kind() {
+		case reflect.Struct, reflect.Ptr:
+			validateValue(fv, name, errs)
+		case reflect.Slice, reflect.Array:
+			for j := 0; j < fv.Len(); j++ {
+				validateValue(fv.Index(j), fmt.Sprintf("%s[%d]", name, j), errs)
+			}
+		}
+	}
+}
+
+// checkRules returns what is wrong with a value, or "" when it passes
+func checkRules(v reflect.Value, tag string) string {
+	// Pointers mark fields that may be left out, as in PATCH bodies
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	empty := isEmpty(v)
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if name == "required" {
+			if empty {
+				return "is required"
+			}
+			continue
+		}
+		// Optional strings and lists are only checked when given
+		if empty && !v.CanInt() && !v.CanFloat() {
+			return ""
+		}
+		switch name {
+		case "email":
+			if !validEmail(v.String()) {
+				return "must be a valid email address"
+			}
+		case "min", "max":
+			limit, _ := strconv.ParseFloat(arg, 64)
+			n, unit := measure(v)
+			if name == "min" && n < limit {
+				return "must be at least " + arg + unit
+			}
+			if name == "max" && n > limit {
+				return "must be at most " + arg + unit
+			}
+		case "oneof":
+			allowed := strings.Split(arg, "|")
+			if !contains(allowed, fmt.Sprint(v.Interface())) {
+				return "must be one of " + strings.Join(allowed, ", ")
+			}
+		default:
+			list, ok := validationLists[name]
+			if !ok {
+				panic("validate: unknown rule " + name)
+			}
+			if !contains(list(), v.String()) {
+				return "must be one of " + strings.Join(list(), ", ")
+			}
+		}
+	}
+	return ""
+}
+
+// measure is a number's value, or a string's or list's length with its unit
+func measure(v reflect.Value) (float64, string) {
+	switch {
+	case v.CanInt():
+		return float64(v.Int()), ""
+	case v.CanFloat():
+		return v.Float(), ""
+	case v.Kind() == reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), " characters"
+	default:
+		return float64(v.Len()), " items"
+	}
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+// validEmail accepts a bare address with a dotted domain, no display name
+func validEmail(s string) bool {
+	s = strings.TrimSpace(s)
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s || addr.Name != "" {
+		return false
+	}
+	_, domain, _ := strings.Cut(s, "@")
+	return strings.Contains(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// jsonTypeName describes a Go type the way a JSON client would see it
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	default:
+		return "an object"
+	}
+}
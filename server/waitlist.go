@@ -0,0 +1,514 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WaitlistEntry is a visitor queuing for a sold-out slot
+type WaitlistEntry struct {
+	ID             int64  `json:"id"`
+	SlotID         int64  `json:"slotId" validate:"required"`
+	Name           string `json:"name" validate:"required,max=200"`
+	Email          string `json:"email" validate:"required,email"`
+	PartySize      int    `json:"partySize" validate:"min=1,max=500"`
+	Status         string `json:"status"` // waiting, offered, booked, expired, removed
+	OfferExpiresAt string `json:"offerExpiresAt,omitempty"`
+	Locale         string `json:"locale"` // language the visitor joined in
+	CreatedAt      string `json:"createdAt"`
+
+	// Slot details, filled in for the admin queue
+	Activity  string `json:"activity,omitempty"`
+	SlotStart string `json:"slotStart,omitempty"`
+}
+
+func initWaitlistTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS waitlist (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		slot_id INTEGER,
+		name TEXT,
+		email TEXT,
+		party_size INTEGER,
+		status TEXT DEFAULT 'waiting',
+		offer_token TEXT UNIQUE,
+		offer_expires_at DATETIME,
+		booking_id INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(slot_id) REFERENCES slots(id),
+		FOREIGN KEY(booking_id) REFERENCES bookings(id)
+	);`
+	if _, err := db.Exec(query); err != nil {
+		log.Printf("Error creating waitlist tables: %v", err)
+	}
+
+	// Migration: when an unpaid booking's seat hold lapses. Bookings made
+	// before holds existed have none and are never expired.
+	db.Exec("ALTER TABLE bookings ADD COLUMN hold_expires_at DATETIME")
+}
+
+// envMinutes reads a duration in minutes from the environment
+func envMinutes(name string, def int) time.Duration {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n > 0 {
+		return time.Duration(n) * time.Minute
+	}
+	return time.Duration(def) * time.Minute
+}
+
+// bookingHoldTTL is how long an unpaid booking keeps its seats
+func bookingHoldTTL() time.Duration { return envMinutes("BOOKING_HOLD_MINUTES", 30) }
+
+// waitlistOfferTTL is how long a waitlisted visitor has to book freed seats
+func waitlistOfferTTL() time.Duration { return envMinutes("WAITLIST_OFFER_MINUTES", 120) }
+
+// heldSeats counts seats reserved by open waitlist offers on a slot.
+// exceptToken excludes the caller's own offer.
+func heldSeats(q queryer, slotID int64, exceptToken string) int {
+	var held int
+	q.QueryRow(`SELECT COALESCE(SUM(party_size), 0) FROM waitlist
+		WHERE slot_id = ? AND status = 'offered' AND offer_expires_at > ? AND offer_token != ?`,
+		slotID, dbTime(time.Now()), exceptToken).Scan(&held)
+	return held
+}
+
+// offerFreedSeats emails the first waiting entries whose party fits in the
+// slot's free seats a time-limited booking link. Offered seats are held for
+// the offer's lifetime so they can't be taken by the public booking form.
+func offerFreedSeats(slotID int64) {
+	type offer struct {
+		entry WaitlistEntry
+		token string
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting waitlist offer for slot %d: %v", slotID, err)
+		return
+	}
+
+	var capacity, booked int
+	var activitySlug, startStr string
+	err = tx.QueryRow("SELECT activity, start_time, capacity, booked FROM slots WHERE id = ? AND cancelled_at IS NULL", slotID).Scan(&activitySlug, &startStr, &capacity, &booked)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	start, err := parseSlotTime(startStr)
+	now := time.Now()
+	if err != nil || !start.After(now) {
+		tx.Rollback()
+		return
+	}
+
+	free := capacity - booked - heldSeats(tx, slotID, "")
+	if free <= 0 {
+		tx.Rollback()
+		return
+	}
+
+	rows, err := tx.Query("SELECT id, name, email, party_size, COALESCE(locale, '') FROM waitlist WHERE slot_id = ? AND status = 'waiting' ORDER BY created_at ASC, id ASC", slotID)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error reading waitlist for slot %d: %v", slotID, err)
+		return
+	}
+	var waiting []WaitlistEntry
+	for rows.Next() {
+		var e WaitlistEntry
+		if err := rows.Scan(&e.ID, &e.Name, &e.Email, &e.PartySize, &e.Locale); err == nil {
+			waiting = append(waiting, e)
+		}
+	}
+	rows.Close()
+
+	// An offer never outlives the slot itself
+	expires := now.Add(waitlistOfferTTL())
+	if expires.After(start) {
+		expires = start
+	}
+
+	var offers []offer
+	for _, e := range waiting {
+		if e.PartySize > free {
+			continue // a smaller party further back may still fit
+		}
+		token := newToken()
+		if _, err := tx.Exec("UPDATE waitlist SET status = 'offered', offer_token = ?, offer_expires_at = ? WHERE id = ?", token, dbTime(expires), e.ID); err != nil {
+			tx.Rollback()
+			log.Printf("Error offering waitlist entry %d: %v", e.ID, err)
+			return
+		}
+		free -= e.PartySize
+		offers = append(offers, offer{entry: e, token: token})
+		if free == 0 {
+			break
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing waitlist offers for slot %d: %v", slotID, err)
+		return
+	}
+
+	when := start.In(farmLocation).Format("2006-01-02 15:04")
+	deadline := expires.In(farmLocation).Format("2006-01-02 15:04")
+	for _, o := range offers {
+		log.Printf("⏳ Waitlist: offered %d seat(s) on slot #%d to %s", o.entry.PartySize, slotID, o.entry.Email)
+		locale := preferredLocale(o.entry.Email, o.entry.Locale)
+		sendCustomerEmail(o.entry.Email, locale, "waitlist_offer", map[string]interface{}{
+			"Name":      o.entry.Name,
+			"PartySize": o.entry.PartySize,
+			"Activity":  activityNameIn(activitySlug, locale),
+			"When":      when,
+			"Deadline":  deadline,
+			"URL":       publicURL("/book-visit.html?offer=" + o.token),
+		})
+	}
+}
+
+// expireBookingHolds releases seats held by bookings that were never paid
+// and returns the slots that got seats back
+func expireBookingHolds() []int64 {
+	rows, err := db.Query("SELECT id, slot_id, quantity FROM bookings WHERE status = 'pending' AND hold_expires_at < ?", dbTime(time.Now()))
+	if err != nil {
+		log.Printf("Error reading expired booking holds: %v", err)
+		return nil
+	}
+	type hold struct {
+		id, slotID int64
+		quantity   int
+	}
+	var holds []hold
+	for rows.Next() {
+		var h hold
+		if err := rows.Scan(&h.id, &h.slotID, &h.quantity); err == nil {
+			holds = append(holds, h)
+		}
+	}
+	rows.Close()
+
+	var slots []int64
+	for _, h := range holds {
+		if err := releaseBooking(h.id, h.slotID, h.quantity, "pending", "expired"); err != nil {
+			log.Printf("Error expiring booking %d: %v", h.id, err)
+			continue
+		}
+		log.Printf("⌛ Booking #%d was not paid in time, released %d seat(s) on slot #%d", h.id, h.quantity, h.slotID)
+		slots = append(slots, h.slotID)
+	}
+	return slots
+}
+
+// releaseBooking moves a booking from one status to another and gives its
+// seats back to the slot. It fails if the booking is no longer in fromStatus.
+func releaseBooking(bookingID, slotID int64, quantity int, fromStatus, toStatus string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("UPDATE bookings SET status = ? WHERE id = ? AND status = ?", toStatus, bookingID, fromStatus)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return fmt.Errorf("booking %d is no longer %s", bookingID, fromStatus)
+	}
+	if _, err := tx.Exec("UPDATE slots SET booked = MAX(booked - ?, 0) WHERE id = ?", quantity, slotID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// A waitlisted visitor who let their booking lapse doesn't rejoin the queue
+	if _, err := tx.Exec("UPDATE waitlist SET status = ? WHERE booking_id = ?", toStatus, bookingID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// expireWaitlistOffers closes offers nobody acted on and returns their slots
+func expireWaitlistOffers() []int64 {
+	now := dbTime(time.Now())
+	rows, err := db.Query("SELECT DISTINCT slot_id FROM waitlist WHERE status = 'offered' AND offer_expires_at <= ?", now)
+	if err != nil {
+		log.Printf("Error reading expired waitlist offers: %v", err)
+		return nil
+	}
+	var slots []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			slots = append(slots, id)
+		}
+	}
+	rows.Close()
+
+	if len(slots) > 0 {
+		db.Exec("UPDATE waitlist SET status = 'expired' WHERE status = 'offered' AND offer_expires_at <= ?", now)
+	}
+	return slots
+}
+
+// runBookingSweeper periodically expires unpaid holds and stale offers, then
+// offers the freed seats to the next people in line. Finished slots get their
+// no-shows recorded and abandoned shop orders give back their stock.
+func runBookingSweeper() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		seen := map[int64]bool{}
+		for _, id := range append(expireBookingHolds(), expireWaitlistOffers()...) {
+			if !seen[id] {
+				seen[id] = true
+				offerFreedSeats(id)
+			}
+		}
+		closeEndedSlots()
+		expireUnpaidOrders()
+		runDiaryDigest()
+		<-ticker.C
+	}
+}
+
+// listWaitlist returns queue entries with their slot details. Without a slot
+// filter it returns every open entry (waiting or offered) on upcoming slots.
+func listWaitlist(slotID int64) ([]WaitlistEntry, error) {
+	query := `
+		SELECT w.id, w.slot_id, w.name, w.email, w.party_size, w.status, COALESCE(w.offer_expires_at, ''), w.created_at, s.activity, s.start_time
+		FROM waitlist w JOIN slots s ON w.slot_id = s.id
+		WHERE w.status IN ('waiting', 'offered') AND s.start_time > CURRENT_TIMESTAMP`
+	args := []interface{}{}
+	if slotID != 0 {
+		query += " AND w.slot_id = ?"
+		args = append(args, slotID)
+	}
+	query += " ORDER BY s.start_time ASC, w.created_at ASC, w.id ASC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []WaitlistEntry
+	for rows.Next() {
+		var e WaitlistEntry
+		if err := rows.Scan(&e.ID, &e.SlotID, &e.Name, &e.Email, &e.PartySize, &e.Status, &e.OfferExpiresAt, &e.CreatedAt, &e.Activity, &e.SlotStart); err != nil {
+			continue
+		}
+		e.SlotStart = farmTime(e.SlotStart)
+		if e.OfferExpiresAt != "" {
+			e.OfferExpiresAt = farmTime(e.OfferExpiresAt)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// handleWaitlist manages the queue for sold-out slots.
+// GET lists open entries (?slotId= filters), POST joins the queue for a slot
+// and DELETE ?id= removes an entry, passing any seats it was offered on.
+// Only joining is public; listing and removing need a staff key.
+func handleWaitlist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		if _, ok := requireStaff(w, r); !ok {
+			return
+		}
+	}
+	switch r.Method {
+	case http.MethodGet:
+		slotID, _ := strconv.ParseInt(r.URL.Query().Get("slotId"), 10, 64)
+		entries, err := listWaitlist(slotID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if entries == nil {
+			entries = []WaitlistEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+
+	case http.MethodPost:
+		var e WaitlistEntry
+		if !decodeJSON(w, r, &e) {
+			return
+		}
+		e.Name = strings.TrimSpace(e.Name)
+		e.Email = strings.TrimSpace(e.Email)
+
+		var capacity int
+		var startStr string
+		if err := db.QueryRow("SELECT capacity, start_time FROM slots WHERE id = ? AND private_token IS NULL AND cancelled_at IS NULL", e.SlotID).Scan(&capacity, &startStr); err != nil {
+			writeError(w, http.StatusNotFound, "Slot not found")
+			return
+		}
+		if start, err := parseSlotTime(startStr); err != nil || !start.After(time.Now()) {
+			writeError(w, http.StatusBadRequest, "Slot has already started")
+			return
+		}
+		if e.PartySize > capacity {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Party of %d can never fit in this slot (capacity %d)", e.PartySize, capacity))
+			return
+		}
+
+		var existing int
+		db.QueryRow("SELECT COUNT(*) FROM waitlist WHERE slot_id = ? AND LOWER(email) = LOWER(?) AND status IN ('waiting', 'offered')", e.SlotID, e.Email).Scan(&existing)
+		if existing > 0 {
+			writeError(w, http.StatusConflict, "Already on the waitlist for this slot")
+			return
+		}
+
+		contactID, err := contactIDFor(db, e.Email, e.Name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		res, err := db.Exec("INSERT INTO waitlist (slot_id, name, email, party_size, locale, contact_id) VALUES (?, ?, ?, ?, ?, ?)",
+			e.SlotID, e.Name, e.Email, e.PartySize, requestLocale(r), contactID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		e.ID, _ = res.LastInsertId()
+
+		var position int
+		db.QueryRow("SELECT COUNT(*) FROM waitlist WHERE slot_id = ? AND status IN ('waiting', 'offered') AND id <= ?", e.SlotID, e.ID).Scan(&position)
+
+		log.Printf("⏳ Waitlist: %s joined slot #%d with %d pers (position %d)", e.Email, e.SlotID, e.PartySize, position)
+		sendCustomerEmail(e.Email, preferredLocale(e.Email, requestLocale(r)), "waitlist_joined", map[string]interface{}{
+			"Name":      e.Name,
+			"Position":  position,
+			"PartySize": e.PartySize,
+		})
+
+		// Seats may already be free, e.g. a hold lapsed since the visitor loaded the page
+		offerFreedSeats(e.SlotID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": e.ID, "position": position})
+
+	case http.MethodDelete:
+		id, _ := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+		var slotID int64
+		var status string
+		if err := db.QueryRow("SELECT slot_id, status FROM waitlist WHERE id = ?", id).Scan(&slotID, &status); err != nil {
+			writeError(w, http.StatusNotFound, "Waitlist entry not found")
+			return
+		}
+		if _, err := db.Exec("UPDATE waitlist SET status = 'removed' WHERE id = ? AND status IN ('waiting', 'offered')", id); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if status == "offered" {
+			offerFreedSeats(slotID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleWaitlistOffer lets the booking page resolve an emailed offer link.
+// GET /api/waitlist/offer?token=
+func handleWaitlistOffer(w http.ResponseWriter, r *http.Request) {
+	var e WaitlistEntry
+	var s Slot
+	err := db.QueryRow(`
+		SELECT w.id, w.slot_id, w.name, w.email, w.party_size, w.status, w.offer_expires_at,
+			s.id, s.activity, s.start_time, s.end_time, s.capacity, s.booked
+		FROM waitlist w JOIN slots s ON w.slot_id = s.id
+		WHERE w.offer_token = ?`, r.URL.Query().Get("token")).Scan(
+		&e.ID, &e.SlotID, &e.Name, &e.Email, &e.PartySize, &e.Status, &e.OfferExpiresAt,
+		&s.ID, &s.Activity, &s.StartTime, &s.EndTime, &s.Capacity, &s.Booked)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "Offer not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	expires, _ := parseSlotTime(e.OfferExpiresAt)
+	if e.Status != "offered" || !expires.After(time.Now()) {
+		writeError(w, http.StatusGone, "This offer has expired")
+		return
+	}
+
+	e.OfferExpiresAt = farmTime(e.OfferExpiresAt)
+	s.localize()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entry": e,
+		"slot":  s,
+	})
+}
+
+// handleCancelBooking lets a visitor cancel with the token from their
+// confirmation email. The seats go straight to the waitlist.
+// POST /api/bookings/cancel {"token": "..."}
+func handleCancelBooking(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token" validate:"max=64"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Token == "" {
+		writeError(w, http.StatusUnauthorized, "Missing token")
+		return
+	}
+
+	var bookingID, slotID int64
+	var quantity int
+	var total float64
+	var status, name, email, startStr, locale string
+	err := db.QueryRow(`
+		SELECT b.id, b.slot_id, b.quantity, COALESCE(b.total_amount, 0), b.status, b.customer_name, b.customer_email, s.start_time, COALESCE(b.locale, '')
+		FROM bookings b JOIN slots s ON b.slot_id = s.id
+		WHERE b.access_token = ?`, req.Token).Scan(&bookingID, &slotID, &quantity, &total, &status, &name, &email, &startStr, &locale)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Booking not found")
+		return
+	}
+	if status != "pending" && status != "paid" && status != "confirmed" {
+		writeError(w, http.StatusConflict, "Booking is already "+status)
+		return
+	}
+	if start, err := parseSlotTime(startStr); err != nil || !start.After(time.Now()) {
+		writeError(w, http.StatusConflict, "The visit has already started")
+		return
+	}
+
+	if err := releaseBooking(bookingID, slotID, quantity, status, "cancelled"); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	log.Printf("🚫 Booking #%d cancelled by visitor, released %d seat(s) on slot #%d", bookingID, quantity, slotID)
+	refund := 0.0
+	if status != "pending" {
+		log.Printf("💸 MOCK: Refund of €%.2f issued for booking #%d", total, bookingID)
+		refund = total
+	}
+	sendCustomerEmail(email, preferredLocale(email, locale), "booking_cancelled", map[string]interface{}{
+		"Name":      name,
+		"BookingID": bookingID,
+		"Refund":    refund,
+	})
+
+	offerFreedSeats(slotID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
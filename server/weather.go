@@ -0,0 +1,529 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Forecast is the expected weather at the farm for one hour
+type Forecast struct {
+	Time          time.Time `json:"time"`
+	WindSpeed     float64   `json:"windSpeed"`     // m/s
+	Precipitation float64   `json:"precipitation"` // mm per hour
+	Summary       string    `json:"summary,omitempty"`
+}
+
+// WeatherProvider returns hourly forecasts covering [from, to)
+type WeatherProvider interface {
+	Forecast(from, to time.Time) ([]Forecast, error)
+}
+
+// fileWeatherProvider reads forecasts from a local JSON array, so staff can
+// test the alerts without a weather service. The file is re-read on every
+// call, edits show up on the next page load.
+type fileWeatherProvider struct {
+	path string
+}
+
+func (p fileWeatherProvider) Forecast(from, to time.Time) ([]Forecast, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	var all []Forecast
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("%s: %v", p.path, err)
+	}
+	var hours []Forecast
+	for _, f := range all {
+		if !f.Time.Before(from) && f.Time.Before(to) {
+			hours = append(hours, f)
+		}
+	}
+	return hours, nil
+}
+
+// weatherProvider is nil when no provider is configured; the admin then
+// simply sees no weather flags
+var weatherProvider WeatherProvider
+
+func loadWeatherProvider() {
+	switch os.Getenv("WEATHER_PROVIDER") {
+	case "":
+	case "file":
+		path := os.Getenv("WEATHER_FILE")
+		if path == "" {
+			path = "./weather.json"
+		}
+		weatherProvider = fileWeatherProvider{path: path}
+		log.Printf("🌦️  Weather forecasts read from %s", path)
+	default:
+		log.Printf("Unknown WEATHER_PROVIDER %q, weather flags disabled", os.Getenv("WEATHER_PROVIDER"))
+	}
+}
+
+// envFloat reads a positive number from the environment
+func envFloat(name string, def float64) float64 {
+	if f, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil && f > 0 {
+		return f
+	}
+	return def
+}
+
+// WeatherAlert flags an outdoor slot whose forecast exceeds the limits
+type WeatherAlert struct {
+	SlotID        int64    `json:"slotId"`
+	Activity      string   `json:"activity"`
+	StartTime     string   `json:"startTime"`
+	Booked        int      `json:"booked"`
+	WindSpeed     float64  `json:"windSpeed"`     // strongest hour during the slot
+	Precipitation float64  `json:"precipitation"` // wettest hour during the slot
+	Reasons       []string `json:"reasons"`
+}
+
+// weatherAlerts checks upcoming outdoor slots in [from, to) against the
+// forecast. WEATHER_MAX_WIND (m/s) and WEATHER_MAX_RAIN (mm/h) set the limits.
+func weatherAlerts(from, to time.Time) ([]WeatherAlert, error) {
+	alerts := []WeatherAlert{}
+	if weatherProvider == nil {
+		return alerts, nil
+	}
+	forecast, err := weatherProvider.Forecast(from.Truncate(time.Hour), to)
+	if err != nil {
+		return nil, err
+	}
+	maxWind := envFloat("WEATHER_MAX_WIND", 10)
+	maxRain := envFloat("WEATHER_MAX_RAIN", 2)
+
+	rows, err := db.Query(`
+		SELECT s.id, s.activity, s.start_time, s.end_time, s.booked
+		FROM slots s JOIN activities a ON a.slug = s.activity
+		WHERE a.outdoor = 1 AND s.cancelled_at IS NULL AND s.start_time >= ? AND s.start_time < ?
+		ORDER BY s.start_time ASC`, dbTime(from), dbTime(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a WeatherAlert
+		var startStr, endStr string
+		if err := rows.Scan(&a.SlotID, &a.Activity, &startStr, &endStr, &a.Booked); err != nil {
+			continue
+		}
+		start, err1 := parseSlotTime(startStr)
+		end, err2 := parseSlotTime(endStr)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		// An hourly forecast covers the slot if its hour overlaps [start, end)
+		for _, f := range forecast {
+			if f.Time.Before(end) && f.Time.Add(time.Hour).After(start) {
+				a.WindSpeed = math.Max(a.WindSpeed, f.WindSpeed)
+				a.Precipitation = math.Max(a.Precipitation, f.Precipitation)
+			}
+		}
+		if a.WindSpeed > maxWind {
+			a.Reasons = append(a.Reasons, fmt.Sprintf("vind %.0f m/s", a.WindSpeed))
+		}
+		if a.Precipitation > maxRain {
+			a.Reasons = append(a.Reasons, fmt.Sprintf("regn %.1f mm/h", a.Precipitation))
+		}
+		if len(a.Reasons) == 0 {
+			continue
+		}
+		a.StartTime = farmTime(startStr)
+		alerts = append(alerts, a)
+	}
+	return alerts, nil
+}
+
+func initWeatherTables() {
+	// Migrations: slots the farm calls off stay in the table for the booking history
+	db.Exec("ALTER TABLE slots ADD COLUMN cancelled_at DATETIME")
+	db.Exec("ALTER TABLE slots ADD COLUMN cancel_reason TEXT DEFAULT ''")
+}
+
+// cancelledEmail is queued inside the cancel transaction and sent after commit
+type cancelledEmail struct {
+	to, locale, template string
+	data                 map[string]interface{}
+}
+
+// cancelSlot calls off one slot: unpaid bookings are dropped, paid ones wait
+// for the visitor to choose a new time or a refund, and the waitlist is
+// cleared. It returns how many people were notified, or 0 and no error if
+// the slot was already cancelled.
+func cancelSlot(slotID int64, reason, message string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var activitySlug, startStr string
+	if err := tx.QueryRow("SELECT activity, start_time FROM slots WHERE id = ?", slotID).Scan(&activitySlug, &startStr); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	res, err := tx.Exec("UPDATE slots SET cancelled_at = ?, cancel_reason = ? WHERE id = ? AND cancelled_at IS NULL", dbTime(time.Now()), reason, slotID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return 0, nil
+	}
+	// Guides and vehicles are free for other slots again
+	if _, err := tx.Exec("DELETE FROM slot_resources WHERE slot_id = ?", slotID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	start, _ := parseSlotTime(startStr)
+	when := start.In(farmLocation).Format("2006-01-02 15:04")
+	var emails []cancelledEmail
+
+	rows, err := tx.Query(`SELECT id, customer_name, customer_email, quantity, COALESCE(total_amount, 0), status, COALESCE(access_token, ''), COALESCE(locale, '')
+		FROM bookings WHERE slot_id = ? AND status IN ('pending', 'paid', 'confirmed')`, slotID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	type affected struct {
+		id                  int64
+		name, email, status string
+		token, locale       string
+		quantity            int
+		total               float64
+	}
+	var bookings []affected
+	for rows.Next() {
+		var b affected
+		if err := rows.Scan(&b.id, &b.name, &b.email, &b.quantity, &b.total, &b.status, &b.token, &b.locale); err != nil {
+			continue
+		}
+		bookings = append(bookings, b)
+	}
+	rows.Close()
+
+	for _, b := range bookings {
+		newStatus, tmplName := "slot_cancelled", "slot_cancelled_paid"
+		url := publicURL("/rebook.html?token=" + b.token)
+		if b.status == "pending" {
+			newStatus, tmplName = "cancelled", "slot_cancelled_pending"
+			url = publicURL("/book-visit.html?activity=" + activitySlug)
+		}
+		if _, err := tx.Exec("UPDATE bookings SET status = ? WHERE id = ?", newStatus, b.id); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if _, err := tx.Exec("UPDATE slots SET booked = MAX(booked - ?, 0) WHERE id = ?", b.quantity, slotID); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		emails = append(emails, cancelledEmail{
+			to: b.email, locale: b.locale, template: tmplName,
+			data: map[string]interface{}{"Name": b.name, "Total": b.total, "URL": url},
+		})
+	}
+
+	rows, err = tx.Query("SELECT name, email, COALESCE(locale, '') FROM waitlist WHERE slot_id = ? AND status IN ('waiting', 'offered')", slotID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	for rows.Next() {
+		var name, email, locale string
+		if err := rows.Scan(&name, &email, &locale); err != nil {
+			continue
+		}
+		emails = append(emails, cancelledEmail{
+			to: email, locale: locale, template: "slot_cancelled_waitlist",
+			data: map[string]interface{}{"Name": name, "URL": publicURL("/book-visit.html?activity=" + activitySlug)},
+		})
+	}
+	rows.Close()
+	if _, err := tx.Exec("UPDATE waitlist SET status = 'cancelled' WHERE slot_id = ? AND status IN ('waiting', 'offered')", slotID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("⛈️  Slot #%d (%s) cancelled: %s – notifying %d", slotID, activitySlug, reason, len(emails))
+	for _, e := range emails {
+		locale := preferredLocale(e.to, e.locale)
+		e.data["Activity"] = activityNameIn(activitySlug, locale)
+		e.data["When"], e.data["Reason"], e.data["Message"] = when, reason, message
+		sendCustomerEmail(e.to, locale, e.template, e.data)
+	}
+	return len(emails), nil
+}
+
+// handleCancelSlots is the admin bulk cancel: one slot by id, or every slot
+// of an activity on a farm-local date.
+// POST /api/slots/cancel {"slotId": 1} or {"activity": "safari", "date": "2027-07-03"}
+// with an optional "reason" and personal "message" for the email. It emails
+// every booked and waitlisted visitor, so it needs a staff key.
+func handleCancelSlots(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		SlotID   int64  `json:"slotId" validate:"min=0"`
+		Activity string `json:"activity" validate:"max=100"`
+		Date     string `json:"date"` // YYYY-MM-DD, farm time
+		Reason   string `json:"reason" validate:"max=200"`
+		Message  string `json:"message" validate:"max=5000"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+
+	var slotIDs []int64
+	switch {
+	case req.SlotID != 0:
+		slotIDs = []int64{req.SlotID}
+	case req.Activity != "" && req.Date != "":
+		day, err := time.ParseInLocation("2006-01-02", req.Date, farmLocation)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid date, expected YYYY-MM-DD")
+			return
+		}
+		rows, err := db.Query("SELECT id FROM slots WHERE activity = ? AND start_time >= ? AND start_time < ? AND cancelled_at IS NULL",
+			req.Activity, dbTime(day), dbTime(day.AddDate(0, 0, 1)))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for rows.Next() {
+			var id int64
+			if rows.Scan(&id) == nil {
+				slotIDs = append(slotIDs, id)
+			}
+		}
+		rows.Close()
+	default:
+		writeError(w, http.StatusBadRequest, "Give slotId, or activity and date")
+		return
+	}
+
+	cancelled := []int64{}
+	notified := 0
+	for _, id := range slotIDs {
+		var startStr string
+		var alreadyCancelled bool
+		if err := db.QueryRow("SELECT start_time, cancelled_at IS NOT NULL FROM slots WHERE id = ?", id).Scan(&startStr, &alreadyCancelled); err != nil {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Slot %d not found", id))
+			return
+		}
+		if start, err := parseSlotTime(startStr); alreadyCancelled || err != nil || !start.After(time.Now()) {
+			continue // past slots are history, not something to call off
+		}
+		n, err := cancelSlot(id, req.Reason, req.Message)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		cancelled = append(cancelled, id)
+		notified += n
+	}
+	if len(cancelled) > 0 {
+		log.Printf("⛈️  Slot(s) %v cancelled by staff #%d", cancelled, staffID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"cancelled": cancelled,
+		"notified":  notified,
+	})
+}
+
+// handleWeatherAlerts lists flagged outdoor slots for the next days.
+// GET /api/weather/alerts?days=7
+func handleWeatherAlerts(w http.ResponseWriter, r *http.Request) {
+	days := 7
+	if s := r.URL.Query().Get("days"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 16 {
+			writeError(w, http.StatusBadRequest, "days must be 1-16")
+			return
+		}
+		days = n
+	}
+	now := time.Now()
+	alerts, err := weatherAlerts(now, now.AddDate(0, 0, days))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "Weather forecast unavailable: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// handleRebook is the visitor's side of a farm cancellation, reached from the
+// emailed link.
+// GET /api/bookings/rebook?token= shows the booking and other times;
+// POST {"token", "choice": "reschedule"|"refund", "slotId"} settles it.
+func handleRebook(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	var req struct {
+		Token  string `json:"token" validate:"max=64"`
+		Choice string `json:"choice" validate:"required,oneof=refund|reschedule"`
+		SlotID int64  `json:"slotId" validate:"min=0"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		token = req.Token
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "Missing token")
+		return
+	}
+
+	var bookingID, slotID int64
+	var quantity int
+	var total float64
+	var status, name, email, activitySlug, startStr, reason, locale string
+	err := db.QueryRow(`
+		SELECT b.id, b.slot_id, b.quantity, COALESCE(b.total_amount, 0), b.status, b.customer_name, b.customer_email,
+			s.activity, s.start_time, COALESCE(s.cancel_reason, ''), COALESCE(b.locale, '')
+		FROM bookings b JOIN slots s ON b.slot_id = s.id
+		WHERE b.access_token = ?`, token).Scan(&bookingID, &slotID, &quantity, &total, &status, &name, &email, &activitySlug, &startStr, &reason, &locale)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Booking not found")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		resp := map[string]interface{}{
+			"bookingId":    bookingID,
+			"customerName": name,
+			"quantity":     quantity,
+			"totalAmount":  total,
+			"status":       status,
+			"activity":     activityNameIn(activitySlug, requestLocale(r)),
+			"startTime":    farmTime(startStr),
+			"reason":       reason,
+		}
+		if status == "slot_cancelled" {
+			start, _ := parseSlotTime(startStr)
+			alternatives, err := alternativeSlots(activitySlug, start, quantity, 10)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			resp["alternatives"] = alternatives
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if status != "slot_cancelled" {
+		writeError(w, http.StatusConflict, "Booking is "+status+", there is nothing to choose")
+		return
+	}
+
+	switch req.Choice {
+	case "refund":
+		res, err := db.Exec("UPDATE bookings SET status = 'refunded' WHERE id = ? AND status = 'slot_cancelled'", bookingID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, http.StatusConflict, "Booking was already settled")
+			return
+		}
+		log.Printf("💸 MOCK: Refund of €%.2f issued for booking #%d (cancelled slot #%d)", total, bookingID, slotID)
+		sendCustomerEmail(email, preferredLocale(email, locale), "refund", map[string]interface{}{
+			"Name":      name,
+			"BookingID": bookingID,
+			"Total":     total,
+		})
+
+	case "reschedule":
+		newStart, err := rescheduleBooking(bookingID, slotID, req.SlotID, activitySlug, quantity)
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		log.Printf("🔁 Booking #%d moved from cancelled slot #%d to #%d", bookingID, slotID, req.SlotID)
+		locale = preferredLocale(email, locale)
+		sendCustomerEmail(email, locale, "rescheduled", map[string]interface{}{
+			"Name":      name,
+			"BookingID": bookingID,
+			"Activity":  activityNameIn(activitySlug, locale),
+			"When":      newStart.In(farmLocation).Format("2006-01-02 15:04"),
+			"URL":       publicURL("/ticket.html?token=" + token),
+		})
+
+	default:
+		writeError(w, http.StatusBadRequest, "choice must be reschedule or refund")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// rescheduleBooking moves a booking off a cancelled slot onto another public
+// slot of the same activity, keeping its payment and ticket code
+func rescheduleBooking(bookingID, fromSlotID, toSlotID int64, activitySlug string, quantity int) (time.Time, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	var activity, startStr string
+	var capacity, booked int
+	var private sql.NullString
+	err = tx.QueryRow("SELECT activity, start_time, capacity, booked, private_token FROM slots WHERE id = ? AND cancelled_at IS NULL", toSlotID).
+		Scan(&activity, &startStr, &capacity, &booked, &private)
+	if err != nil || private.Valid || activity != activitySlug {
+		return time.Time{}, fmt.Errorf("slot not available")
+	}
+	start, err := parseSlotTime(startStr)
+	if err != nil || !start.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("slot has already started")
+	}
+	if capacity-booked-heldSeats(tx, toSlotID, "") < quantity {
+		return time.Time{}, fmt.Errorf("not enough seats left on that slot")
+	}
+
+	res, err := tx.Exec("UPDATE bookings SET slot_id = ?, status = 'paid', checked_in = 0 WHERE id = ? AND slot_id = ? AND status = 'slot_cancelled'",
+		toSlotID, bookingID, fromSlotID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return time.Time{}, fmt.Errorf("booking was already settled")
+	}
+	if _, err := tx.Exec("UPDATE slots SET booked = booked + ? WHERE id = ?", quantity, toSlotID); err != nil {
+		return time.Time{}, err
+	}
+	return start, tx.Commit()
+}